@@ -6,6 +6,7 @@ require (
 	github.com/dhowden/tag v0.0.0-20230630033851-978a0926ee25
 	github.com/disintegration/imaging v1.6.2
 	github.com/google/uuid v1.5.0
+	github.com/mewkiz/flac v1.0.12
 	github.com/pkg/errors v0.9.1
 	github.com/rjeczalik/notify v0.9.3
 	github.com/sirupsen/logrus v1.9.3
@@ -18,17 +19,19 @@ require (
 	gitlab.com/go-utilities/reflect v0.1.0
 	gitlab.com/go-utilities/strings v0.1.0
 	gitlab.com/mipimipi/yuppie v0.4.2
+	golang.org/x/sys v0.15.0
 	golang.org/x/text v0.14.0
 )
 
 require (
 	github.com/fwojciec/clock v0.0.0-20201211142135-a6f233dec376 // indirect
+	github.com/icza/bitio v1.1.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/mewkiz/pkg v0.0.0-20230226050401-4010bf0fec14 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	gitlab.com/go-utilities/system v0.1.0 // indirect
 	gitlab.com/go-utilities/time v0.1.0 // indirect
 	gitlab.com/go-utilities/xml v0.1.0 // indirect
-	golang.org/x/image v0.0.0-20191009234506-e7c1f5e7dbb8 // indirect
+	golang.org/x/image v0.5.0 // indirect
 	golang.org/x/net v0.19.0 // indirect
-	golang.org/x/sys v0.15.0 // indirect
 )
@@ -8,13 +8,17 @@ import (
 	"gitlab.com/mipimipi/muserv/src/internal/config"
 )
 
+// samplePath is the path of a track file that the path templates are tested
+// against
+var samplePath string
+
 // testCmd represents the test command
 var testCmd = &cobra.Command{
 	Use:   "test",
 	Short: "Verify muserv configuration",
-	Long:  "Check the muserv configuration file for completeness and consistency",
+	Long:  "Check the muserv configuration file for completeness and consistency. Optionally, the path template of a music directory can be tested against the path of a track file",
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := config.Test(); err != nil {
+		if err := config.Test(samplePath); err != nil {
 			fmt.Printf("%v\n", err)
 			os.Exit(1)
 		}
@@ -22,5 +26,6 @@ var testCmd = &cobra.Command{
 }
 
 func init() {
+	testCmd.Flags().StringVarP(&samplePath, "path", "p", "", "absolute path of a track file to test the path template of its music directory against")
 	rootCmd.AddCommand(testCmd)
 }
@@ -8,6 +8,7 @@ import (
 	"os/user"
 	"path"
 	p "path"
+	"runtime"
 	"time"
 
 	"github.com/google/uuid"
@@ -39,6 +40,15 @@ const (
 	cfgFilepath = CfgDir + "/config.json"
 )
 
+// default intervals (in seconds) if they are not set in the configuration
+const (
+	// time without changes after which changes are processed in update modes
+	// notify and hybrid
+	defaultQuietPeriod = 5
+	// interval of reconciliation scans in update mode hybrid
+	defaultScanInterval = 3600
+)
+
 // audioMimeTypes contains the audio mime types that muserv supports
 var audioMimeTypes = map[string]struct{}{
 	"audio/aac":    {},
@@ -47,7 +57,9 @@ var audioMimeTypes = map[string]struct{}{
 	"audio/mpeg":   {},
 	"audio/ogg":    {},
 	"audio/opus":   {},
+	"audio/wav":    {},
 	"audio/x-flac": {},
+	"audio/x-wav":  {},
 }
 
 // imageMimeTypes contains the image mime types that muserv supports
@@ -71,25 +83,39 @@ const (
 	LvlAlbum       LevelType = "album"
 	LvlAlbumArtist LevelType = "albumartist"
 	LvlArtist      LevelType = "artist"
+	LvlComposer    LevelType = "composer"
+	LvlConductor   LevelType = "conductor"
+	LvlDisc        LevelType = "disc"
 	LvlGenre       LevelType = "genre"
 	LvlTrack       LevelType = "track"
+	LvlWork        LevelType = "work"
 )
 
 // IsValid checks if the level type has a valid value
 func (me LevelType) IsValid() (err error) {
-	if me != LvlAlbum && me != LvlAlbumArtist && me != LvlArtist && me != LvlGenre && me != LvlTrack {
+	if me != LvlAlbum && me != LvlAlbumArtist && me != LvlArtist && me != LvlComposer && me != LvlConductor && me != LvlDisc && me != LvlGenre && me != LvlTrack && me != LvlWork {
 		err = fmt.Errorf("%s is no valid hierarchy level", me)
 	}
 	return
 }
 
+// isTagLevel returns true if the nodes of levels of type me correspond to tag
+// values (e.g. genres)
+func (me LevelType) isTagLevel() bool {
+	return me == LvlAlbumArtist || me == LvlArtist || me == LvlComposer || me == LvlConductor || me == LvlGenre || me == LvlWork
+}
+
 // allowedHierarchies contains the allowed successors of a level type in
 // content hierarchies
 var allowedHierarchies = map[LevelType]([]LevelType){
-	LvlGenre:       {LvlAlbumArtist, LvlArtist, LvlAlbum, LvlTrack},
+	LvlGenre:       {LvlAlbumArtist, LvlArtist, LvlComposer, LvlConductor, LvlWork, LvlAlbum, LvlTrack},
 	LvlAlbumArtist: {LvlAlbum},
 	LvlArtist:      {LvlTrack},
-	LvlAlbum:       {LvlTrack},
+	LvlComposer:    {LvlWork, LvlAlbum, LvlTrack},
+	LvlConductor:   {LvlWork, LvlAlbum, LvlTrack},
+	LvlWork:        {LvlAlbum, LvlTrack},
+	LvlAlbum:       {LvlDisc, LvlTrack},
+	LvlDisc:        {LvlTrack},
 	LvlTrack:       {},
 }
 
@@ -108,12 +134,17 @@ type SortField string
 
 // sort field values
 const (
-	SortNone       SortField = ""
-	SortTitle      SortField = "title"
-	SortTrackNo    SortField = "trackNo"
-	SortDiscNo     SortField = "discNo"
-	SortYear       SortField = "year"
-	SortLastChange SortField = "lastChange"
+	SortNone         SortField = ""
+	SortTitle        SortField = "title"
+	SortTrackNo      SortField = "trackNo"
+	SortDiscNo       SortField = "discNo"
+	SortYear         SortField = "year"
+	SortLastChange   SortField = "lastChange"
+	SortDate         SortField = "date"
+	SortOriginalYear SortField = "originalYear"
+	SortMovementNo   SortField = "movementNo"
+	SortRating       SortField = "rating"
+	SortAdded        SortField = "added"
 )
 
 // allowedSortFields contains the allowed sort fields per hierarchy level type.
@@ -121,8 +152,9 @@ const (
 // Those can only be sorted by that single value and thus do not support other
 // sort fields
 var allowedSortFields = map[LevelType]([]SortField){
-	LvlAlbum: {SortTitle, SortYear, SortLastChange},
-	LvlTrack: {SortTitle, SortYear, SortLastChange, SortTrackNo, SortDiscNo},
+	LvlAlbum: {SortTitle, SortYear, SortLastChange, SortDate, SortOriginalYear, SortRating, SortAdded},
+	LvlDisc:  {SortTitle, SortDiscNo},
+	LvlTrack: {SortTitle, SortYear, SortLastChange, SortTrackNo, SortDiscNo, SortDate, SortOriginalYear, SortMovementNo, SortRating, SortAdded},
 }
 
 // Cfg stores the data from the muserv configuration file
@@ -134,15 +166,31 @@ type Cfg struct {
 	LogLevel string `json:"log_level"`
 }
 type cnt struct {
-	MusicDirs        []string      `json:"music_dirs"`
-	Separator        string        `json:"separator"`
-	UpdateMode       string        `json:"update_mode"`
-	UpdateInterval   time.Duration `json:"update_interval"`
-	Hiers            []Hierarchy   `json:"hierarchies"`
-	ShowPlaylists    bool          `json:"show_playlists"`
-	PlaylistHierName string        `json:"playlist_hierarchy_name"`
-	ShowFolders      bool          `json:"show_folders"`
-	FolderHierName   string        `json:"folder_hierarchy_name"`
+	MusicDirs        []string             `json:"music_dirs"`
+	Exclude          []string             `json:"exclude"`         // patterns of files and directories to be ignored
+	FollowSymlinks   bool                 `json:"follow_symlinks"` // follow symbolic links in the music directories
+	Separator        string               `json:"separator"`
+	Separators       Separators           `json:"separators"`
+	TagRules         TagRules             `json:"tag_rules"`
+	ArtistCredits    ArtistCredits        `json:"artist_credits"`
+	Compilations     Compilations         `json:"compilations"`
+	Placeholders     map[LevelType]string `json:"placeholders"`
+	PathTemplates    []PathTemplate       `json:"path_templates"`
+	CharsetRepair    CharsetRepair        `json:"charset_repair"`
+	LoudnessScan     bool                 `json:"loudness_scan"`   // measure loudness of tracks without ReplayGain tags
+	Workers          int                  `json:"workers"`         // number of workers that read tags
+	PictureWorkers   int                  `json:"picture_workers"` // number of workers that process pictures
+	IORateLimit      float64              `json:"io_rate_limit"`   // maximum rate in MB/s to read track files (0 = unlimited)
+	UpdateMode       string               `json:"update_mode"`
+	UpdateInterval   time.Duration        `json:"update_interval"`
+	QuietPeriod      time.Duration        `json:"quiet_period"`  // time without changes after which changes are processed
+	ScanInterval     time.Duration        `json:"scan_interval"` // interval of reconciliation scans in update mode hybrid
+	Hiers            []Hierarchy          `json:"hierarchies"`
+	ShowPlaylists    bool                 `json:"show_playlists"`
+	PlaylistHierName string               `json:"playlist_hierarchy_name"`
+	ShowFolders      bool                 `json:"show_folders"`
+	FolderHierName   string               `json:"folder_hierarchy_name"`
+	excludes         []IgnorePattern
 }
 type upnp struct {
 	Interfaces []string `json:"interfaces"`
@@ -171,15 +219,17 @@ type device struct {
 // of hierarchies they shall appear. Those hierarchies can be removed without
 // problem. For the other hierarchies, Levels must be set.
 type Hierarchy struct {
-	Name   string  `json:"name"`
-	Levels []level `json:"levels"`
+	Name      string  `json:"name"`
+	Levels    []level `json:"levels"`
+	MinRating float64 `json:"min_rating"` // only tracks with at least this rating are part of the hierarchy
 }
 
 type level struct {
-	Type       LevelType `json:"type"`
-	Sort       []string  `json:"sort"`
-	sortFields []SortField
-	comps      []Comparison
+	Type           LevelType `json:"type"`
+	Sort           []string  `json:"sort"`
+	ExcludeMissing bool      `json:"exclude_missing"` // exclude tracks where the tag of the level is empty
+	sortFields     []SortField
+	comps          []Comparison
 }
 
 func (me *level) SortFields() []SortField {
@@ -311,6 +361,32 @@ func (me *cnt) MusicDir(path string) string {
 	return ""
 }
 
+// PathTemplate returns the path template of the music directory that path
+// belongs to. If there is no such template, nil is returned
+func (me *cnt) PathTemplate(path string) *PathTemplate {
+	musicDir := me.MusicDir(path)
+	if len(musicDir) == 0 {
+		return nil
+	}
+	for i := 0; i < len(me.PathTemplates); i++ {
+		if me.PathTemplates[i].MusicDir == musicDir {
+			return &me.PathTemplates[i]
+		}
+	}
+	return nil
+}
+
+// Excludes returns the parsed exclude patterns. They are applied in all music
+// directories
+func (me *cnt) Excludes() []IgnorePattern { return me.excludes }
+
+// Placeholder returns the name that is displayed for hierarchy level nodes of
+// type lvl if the corresponding tag of the tracks is empty. If no placeholder
+// is configured, an empty string is returned
+func (me *cnt) Placeholder(lvl LevelType) string {
+	return me.Placeholders[lvl]
+}
+
 // validate checks if the content part of the configuration is complete and
 // correct. If it's not, an error is returned
 func (me *cnt) validate() (err error) {
@@ -319,7 +395,71 @@ func (me *cnt) validate() (err error) {
 		return
 	}
 
-	if me.UpdateMode != "notify" && me.UpdateMode != "scan" {
+	// parse exclude patterns
+	me.excludes = nil
+	for _, s := range me.Exclude {
+		pat, ok, e := ParseIgnorePattern(s)
+		if e != nil {
+			err = e
+			return
+		}
+		if ok {
+			me.excludes = append(me.excludes, pat)
+		}
+	}
+
+	// validate separators
+	if err = me.Separators.validate(); err != nil {
+		return
+	}
+
+	// validate tag rules
+	if err = me.TagRules.validate(); err != nil {
+		return
+	}
+
+	// validate artist credit configuration
+	if err = me.ArtistCredits.validate(); err != nil {
+		return
+	}
+
+	// validate compilation configuration
+	if err = me.Compilations.validate(); err != nil {
+		return
+	}
+
+	// validate path templates. There can be at most one template per music
+	// directory
+	dirs := make(map[string]struct{})
+	for i := 0; i < len(me.PathTemplates); i++ {
+		if !reflect.Contains(me.MusicDirs, me.PathTemplates[i].MusicDir) {
+			err = fmt.Errorf("path template is configured for '%s', which is not a music dir", me.PathTemplates[i].MusicDir)
+			return
+		}
+		if _, exists := dirs[me.PathTemplates[i].MusicDir]; exists {
+			err = fmt.Errorf("more than one path template is configured for music dir '%s'", me.PathTemplates[i].MusicDir)
+			return
+		}
+		dirs[me.PathTemplates[i].MusicDir] = struct{}{}
+		if err = me.PathTemplates[i].validate(); err != nil {
+			return
+		}
+	}
+
+	// validate charset repair configuration
+	if err = me.CharsetRepair.validate(); err != nil {
+		return
+	}
+
+	// placeholders can only be configured for levels that correspond to tags
+	for lvl := range me.Placeholders {
+		if !lvl.isTagLevel() {
+			err = fmt.Errorf("placeholders cannot be configured for level '%s'", lvl)
+			return
+		}
+	}
+
+	if me.UpdateMode != "notify" && me.UpdateMode != "scan" && me.UpdateMode != "hybrid" {
 		err = fmt.Errorf("unknown update_mode '%s'", me.UpdateMode)
 		return
 	}
@@ -327,6 +467,36 @@ func (me *cnt) validate() (err error) {
 		err = fmt.Errorf("update_interval must be > 0")
 		return
 	}
+	if me.QuietPeriod < 0 {
+		err = fmt.Errorf("quiet_period must be >= 0")
+		return
+	}
+	if me.QuietPeriod == 0 {
+		me.QuietPeriod = defaultQuietPeriod
+	}
+	if me.ScanInterval < 0 {
+		err = fmt.Errorf("scan_interval must be >= 0")
+		return
+	}
+	if me.ScanInterval == 0 {
+		me.ScanInterval = defaultScanInterval
+	}
+
+	// per default, there are as many workers as CPUs
+	if me.Workers < 0 || me.PictureWorkers < 0 {
+		err = fmt.Errorf("workers and picture_workers must be >= 0")
+		return
+	}
+	if me.Workers == 0 {
+		me.Workers = runtime.NumCPU()
+	}
+	if me.PictureWorkers == 0 {
+		me.PictureWorkers = runtime.NumCPU()
+	}
+	if me.IORateLimit < 0 {
+		err = fmt.Errorf("io_rate_limit must be >= 0")
+		return
+	}
 
 	// validate hierarchies
 	if len(me.Hiers) == 0 {
@@ -414,8 +584,10 @@ func (me *upnp) validate() (err error) {
 }
 
 // Test reads the configuration file and checks the configuration for
-// completeness and consistency
-func Test() (err error) {
+// completeness and consistency. If samplePath is not empty, the path template
+// of the corresponding music directory is matched against it and the derived
+// values are printed
+func Test(samplePath string) (err error) {
 	var cfg Cfg
 
 	if cfg, err = Load(); err != nil {
@@ -428,6 +600,35 @@ func Test() (err error) {
 	}
 
 	fmt.Println("Congrats: The muserv configuration is complete and consistent :)")
+
+	if len(samplePath) > 0 {
+		err = testPathTemplate(&cfg, samplePath)
+	}
+	return
+}
+
+// testPathTemplate matches samplePath against the path template of the
+// corresponding music directory and prints the derived values
+func testPathTemplate(cfg *Cfg, samplePath string) (err error) {
+	if !p.IsAbs(samplePath) {
+		err = fmt.Errorf("sample path '%s' is not absolute", samplePath)
+		return
+	}
+	tmpl := cfg.Cnt.PathTemplate(samplePath)
+	if tmpl == nil {
+		err = fmt.Errorf("no path template is configured for '%s'", samplePath)
+		return
+	}
+	values, ok := tmpl.Match(samplePath)
+	if !ok {
+		err = fmt.Errorf("sample path '%s' does not match path template '%s'", samplePath, tmpl.Template)
+		return
+	}
+
+	fmt.Printf("\nSample path '%s' matches path template '%s' (mode: %s):\n", samplePath, tmpl.Template, tmpl.Mode)
+	for _, field := range tmpl.fields {
+		fmt.Printf("  %-12s %s\n", field+":", values[field])
+	}
 	return
 }
 
@@ -452,7 +653,7 @@ func validateSort(s string) (err error) {
 		return
 	}
 	_, sf := splitSort(s)
-	if sf != SortNone && sf != SortTitle && sf != SortTrackNo && sf != SortDiscNo && sf != SortYear && sf != SortLastChange {
+	if sf != SortNone && sf != SortTitle && sf != SortTrackNo && sf != SortDiscNo && sf != SortYear && sf != SortLastChange && sf != SortDate && sf != SortOriginalYear && sf != SortMovementNo && sf != SortRating && sf != SortAdded {
 		err = fmt.Errorf("%s is no valid sort field", s)
 	}
 	return
@@ -494,6 +695,12 @@ func (me *Hierarchy) validate() (err error) {
 		return
 	}
 
+	// ratings are between 0 and 5
+	if me.MinRating < 0 || me.MinRating > 5 {
+		err = fmt.Errorf("min_rating of hierarchy '%s' must be between 0 and 5", me.Name)
+		return
+	}
+
 	// check levels (here, we know already that there is at least one level)
 	for i, level := range me.Levels {
 		// last level must be track
@@ -523,6 +730,12 @@ func (me *Hierarchy) validate() (err error) {
 				err = fmt.Errorf("hierarchy level '%s' cannot be sorted by '%s'", level.Type, sf)
 			}
 		}
+		// tracks with missing tags can only be excluded for levels that
+		// correspond to tags
+		if level.ExcludeMissing && !level.Type.isTagLevel() {
+			err = fmt.Errorf("hierarchy '%s': exclude_missing cannot be set for level '%s'", me.Name, level.Type)
+			return
+		}
 	}
 
 	return
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"fmt"
+	p "path"
+	"regexp"
+	"strings"
+)
+
+// IgnorePattern is a pattern that excludes files or directories from the music
+// directories. It has the semantics of a pattern of a .gitignore file
+type IgnorePattern struct {
+	re       *regexp.Regexp
+	negate   bool // pattern re-includes files that have been excluded before
+	dirOnly  bool // pattern only matches directories
+	anchored bool // pattern is matched against the relative path, otherwise against the name
+}
+
+// ParseIgnorePattern parses a line of an ignore file. ok is false if the line
+// doesn't contain a pattern (i.e. if it's empty or a comment)
+func ParseIgnorePattern(line string) (pat IgnorePattern, ok bool, err error) {
+	// trailing spaces are ignored unless they are escaped
+	s := strings.TrimRight(strings.TrimSuffix(line, "\r"), " ")
+	if strings.HasSuffix(s, "\\") && len(s) < len(strings.TrimSuffix(line, "\r")) {
+		s += " "
+	}
+	if len(s) == 0 || s[0] == '#' {
+		return
+	}
+
+	if s[0] == '!' {
+		pat.negate = true
+		s = s[1:]
+	}
+	if strings.HasSuffix(s, "/") {
+		pat.dirOnly = true
+		s = strings.TrimSuffix(s, "/")
+	}
+	// patterns that contain a slash are relative to the directory of the ignore
+	// file. Otherwise, they match files and directories on any level
+	pat.anchored = strings.Contains(s, "/")
+	s = strings.TrimPrefix(s, "/")
+	if len(s) == 0 {
+		return
+	}
+
+	expr, err := globToRegexp(s)
+	if err == nil {
+		pat.re, err = regexp.Compile("^" + expr + "$")
+	}
+	if err != nil {
+		err = fmt.Errorf("invalid ignore pattern '%s': %v", line, err)
+		return
+	}
+	ok = true
+
+	return
+}
+
+// Match returns true if the pattern matches the path rel, which is relative to
+// the directory of the ignore file. isDir must be true if rel is a directory
+func (me *IgnorePattern) Match(rel string, isDir bool) bool {
+	if me.dirOnly && !isDir {
+		return false
+	}
+	if !me.anchored {
+		rel = p.Base(rel)
+	}
+	return me.re.MatchString(rel)
+}
+
+// Negates returns true if the pattern re-includes files
+func (me *IgnorePattern) Negates() bool { return me.negate }
+
+// globToRegexp converts a glob pattern with the wildcards "*", "?", "[...]" and
+// "**" into a regular expression
+func globToRegexp(glob string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			// "**" matches any number of directories if it's a complete path
+			// segment
+			if strings.HasPrefix(glob[i:], "**") && (i == 0 || glob[i-1] == '/') {
+				if i+2 == len(glob) {
+					b.WriteString(".*")
+					return b.String(), nil
+				}
+				if glob[i+2] == '/' {
+					b.WriteString("(?:.*/)?")
+					i += 2
+					continue
+				}
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			j := i + 1
+			if j < len(glob) && (glob[j] == '!' || glob[j] == '^') {
+				j++
+			}
+			if j < len(glob) && glob[j] == ']' {
+				j++
+			}
+			for j < len(glob) && glob[j] != ']' {
+				j++
+			}
+			if j >= len(glob) {
+				return "", fmt.Errorf("missing ']'")
+			}
+			class := glob[i+1 : j]
+			b.WriteString("[")
+			if class[0] == '!' || class[0] == '^' {
+				b.WriteString("^")
+				class = class[1:]
+			}
+			for k := 0; k < len(class); k++ {
+				if strings.IndexByte(`\[]^`, class[k]) >= 0 {
+					b.WriteByte('\\')
+				}
+				b.WriteByte(class[k])
+			}
+			b.WriteString("]")
+			i = j
+		case '\\':
+			if i+1 == len(glob) {
+				return "", fmt.Errorf("trailing backslash")
+			}
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,125 @@
+package config
+
+import "testing"
+
+func TestGlobToRegexp(t *testing.T) {
+	tests := []struct {
+		glob string
+		want string
+		err  bool
+	}{
+		{glob: "*.flac", want: `[^/]*\.flac`},
+		{glob: "track?.mp3", want: `track[^/]\.mp3`},
+		{glob: "**", want: `.*`},
+		{glob: "**/scans", want: `(?:.*/)?scans`},
+		{glob: "a/**/b", want: `a/(?:.*/)?b`},
+		{glob: "a/**", want: `a/.*`},
+		{glob: "a**b", want: `a[^/]*[^/]*b`},
+		{glob: "[abc].ogg", want: `[abc]\.ogg`},
+		{glob: "[!abc]", want: `[^abc]`},
+		{glob: "[^abc]", want: `[^abc]`},
+		{glob: "[]]", want: `[\]]`},
+		{glob: "[a-z]", want: `[a-z]`},
+		{glob: `\*`, want: `\*`},
+		{glob: `\#`, want: `#`},
+		{glob: "a+b", want: `a\+b`},
+		{glob: "[abc", err: true},
+		{glob: `abc\`, err: true},
+	}
+	for _, tt := range tests {
+		got, err := globToRegexp(tt.glob)
+		if (err != nil) != tt.err {
+			t.Errorf("globToRegexp(%q): error = %v, want error = %t", tt.glob, err, tt.err)
+			continue
+		}
+		if !tt.err && got != tt.want {
+			t.Errorf("globToRegexp(%q) = %q, want %q", tt.glob, got, tt.want)
+		}
+	}
+}
+
+func TestParseIgnorePattern(t *testing.T) {
+	tests := []struct {
+		line   string
+		ok     bool
+		err    bool
+		negate bool
+	}{
+		{line: "", ok: false},
+		{line: "# comment", ok: false},
+		{line: "   ", ok: false},
+		{line: "/", ok: false},
+		{line: "*.jpg", ok: true},
+		{line: "!keep.jpg", ok: true, negate: true},
+		{line: `\#file`, ok: true},
+		{line: "[abc", err: true},
+	}
+	for _, tt := range tests {
+		pat, ok, err := ParseIgnorePattern(tt.line)
+		if (err != nil) != tt.err {
+			t.Errorf("ParseIgnorePattern(%q): error = %v, want error = %t", tt.line, err, tt.err)
+			continue
+		}
+		if ok != tt.ok {
+			t.Errorf("ParseIgnorePattern(%q): ok = %t, want %t", tt.line, ok, tt.ok)
+		}
+		if ok && pat.Negates() != tt.negate {
+			t.Errorf("ParseIgnorePattern(%q): negate = %t, want %t", tt.line, pat.Negates(), tt.negate)
+		}
+	}
+}
+
+func TestIgnorePatternMatch(t *testing.T) {
+	tests := []struct {
+		line  string
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		// patterns without slash match the name on any level
+		{"*.jpg", "cover.jpg", false, true},
+		{"*.jpg", "a/b/cover.jpg", false, true},
+		{"*.jpg", "cover.jpeg", false, false},
+		// patterns with slash are anchored to the directory of the ignore file
+		{"/scans", "scans", true, true},
+		{"/scans", "a/scans", true, false},
+		{"a/*.log", "a/x.log", false, true},
+		{"a/*.log", "a/b/x.log", false, false},
+		{"a/*.log", "b/a/x.log", false, false},
+		// "**" matches any number of directories
+		{"**/scans", "scans", true, true},
+		{"**/scans", "a/b/scans", true, true},
+		{"a/**/b", "a/b", true, true},
+		{"a/**/b", "a/x/y/b", true, true},
+		{"a/**", "a/x/y", false, true},
+		{"a/**", "b/x", false, false},
+		// "*" and "?" don't match slashes
+		{"a/*", "a/b/c", false, false},
+		{"a?b/c", "a/b/c", false, false},
+		// directory-only patterns
+		{"scans/", "scans", true, true},
+		{"scans/", "scans", false, false},
+		// escaped characters and trailing spaces
+		{`\!important`, "!important", false, true},
+		{`\*`, "*", false, true},
+		{`\*`, "x", false, false},
+		{"name  ", "name", false, true},
+		{`name\ `, "name ", false, true},
+		{`name\ `, "name", false, false},
+		// character classes
+		{"[!a]*.flac", "a.flac", false, false},
+		{"[!a]*.flac", "b.flac", false, true},
+		{"disc[0-9]", "disc1", true, true},
+		{"disc[0-9]", "disca", true, false},
+	}
+	for _, tt := range tests {
+		pat, ok, err := ParseIgnorePattern(tt.line)
+		if err != nil || !ok {
+			t.Errorf("ParseIgnorePattern(%q): ok = %t, error = %v", tt.line, ok, err)
+			continue
+		}
+		if got := pat.Match(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("pattern %q: Match(%q, %t) = %t, want %t", tt.line, tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+}
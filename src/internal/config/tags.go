@@ -0,0 +1,500 @@
+package config
+
+import (
+	"fmt"
+	p "path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gitlab.com/go-utilities/filepath"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+// TagName represents the name of a tag that supports multiple values and that
+// can be normalized
+type TagName string
+
+// names of tags that can be normalized
+const (
+	TagAlbumArtist TagName = "albumartist"
+	TagArtist      TagName = "artist"
+	TagComposer    TagName = "composer"
+	TagConductor   TagName = "conductor"
+	TagGenre       TagName = "genre"
+	TagOrchestra   TagName = "orchestra"
+	TagPerformer   TagName = "performer"
+)
+
+// IsValid checks if the tag name has a valid value
+func (me TagName) IsValid() (err error) {
+	if me != TagAlbumArtist && me != TagArtist && me != TagComposer && me != TagConductor && me != TagGenre && me != TagOrchestra && me != TagPerformer {
+		err = fmt.Errorf("%s is no valid tag name", me)
+	}
+	return
+}
+
+// Separators contains the configuration of how the values of tags with
+// multiple values are separated
+type Separators struct {
+	// ByTag maps tag names to the separators that are used for the values of
+	// that tag. For tags that are not contained, the global separator is used
+	ByTag map[TagName][]string `json:"by_tag"`
+	// Escape is a string that can be put in front of a separator in a tag
+	// value to mark it as part of that value (e.g. AC\/DC if Escape is a
+	// backslash and "/" is a separator). If Escape is empty, there is no such
+	// escape mechanism
+	Escape string `json:"escape"`
+	// Protected contains values that are never split, though they contain a
+	// separator (e.g. "AC/DC")
+	Protected []string `json:"protected"`
+}
+
+// Of returns the separators that are used for the values of tag tg. sep is the
+// global separator
+func (me *Separators) Of(tg TagName, sep string) []string {
+	if seps, exists := me.ByTag[tg]; exists && len(seps) > 0 {
+		return seps
+	}
+	return []string{sep}
+}
+
+// validate checks if the separators are correct. If they are not, an error is
+// returned
+func (me *Separators) validate() (err error) {
+	for tg, seps := range me.ByTag {
+		if err = tg.IsValid(); err != nil {
+			err = errors.Wrap(err, "separators are configured for an invalid tag")
+			return
+		}
+		for _, sep := range seps {
+			if len(sep) == 0 {
+				err = fmt.Errorf("separators of tag '%s' must not be empty", tg)
+				return
+			}
+		}
+	}
+	return
+}
+
+// ArtistCredits contains the configuration of the parsing of artist credits
+// (e.g. "Calvin Harris feat. Rihanna" or "Artist A & Artist B"). With it,
+// artist credits are split into main and featured artists
+type ArtistCredits struct {
+	// FeatPhrases contains the phrases that introduce featured artists (e.g.
+	// "feat.", "ft.", "with")
+	FeatPhrases []string `json:"feat_phrases"`
+	// JoinPhrases contains the phrases that join artists of equal rank (e.g.
+	// "&", "x")
+	JoinPhrases []string `json:"join_phrases"`
+	// Exceptions contains artist names that are not split though they contain
+	// a phrase (e.g. "Simon & Garfunkel")
+	Exceptions []string `json:"exceptions"`
+	reFeat     *regexp.Regexp
+	reJoin     *regexp.Regexp
+}
+
+// IsActive returns true if artist credits shall be parsed
+func (me *ArtistCredits) IsActive() bool {
+	return len(me.FeatPhrases) > 0 || len(me.JoinPhrases) > 0
+}
+
+// Parse splits the artist credit into main and featured artists. Phrases
+// are only recognized if they are surrounded by white space. Featured artists
+// can be put in brackets (e.g. "Artist A (feat. Artist B)")
+func (me *ArtistCredits) Parse(credit string) (main, featured []string) {
+	// replace exceptions by placeholders
+	var masked []string
+	for _, exc := range me.Exceptions {
+		if len(exc) == 0 || !strings.Contains(credit, exc) {
+			continue
+		}
+		credit = strings.ReplaceAll(credit, exc, fmt.Sprintf("\x00%d\x00", len(masked)))
+		masked = append(masked, exc)
+	}
+	unmask := func(s string) string {
+		for i := 0; i < len(masked); i++ {
+			s = strings.ReplaceAll(s, fmt.Sprintf("\x00%d\x00", i), masked[i])
+		}
+		return strings.TrimSpace(s)
+	}
+	split := func(s string) (artists []string) {
+		parts := []string{s}
+		if me.reJoin != nil {
+			parts = me.reJoin.Split(s, -1)
+		}
+		for _, part := range parts {
+			if part = unmask(strings.Trim(part, " ()[]")); len(part) > 0 {
+				artists = append(artists, part)
+			}
+		}
+		return
+	}
+
+	parts := []string{credit}
+	if me.reFeat != nil {
+		parts = me.reFeat.Split(credit, -1)
+	}
+	main = split(parts[0])
+	for _, part := range parts[1:] {
+		featured = append(featured, split(part)...)
+	}
+	return
+}
+
+// validate checks the artist credit configuration and compiles the regular
+// expressions that are used for parsing. If the configuration is not correct,
+// an error is returned
+func (me *ArtistCredits) validate() (err error) {
+	compile := func(phrases []string) (re *regexp.Regexp, err error) {
+		if len(phrases) == 0 {
+			return
+		}
+		var quoted []string
+		for _, phrase := range phrases {
+			if len(strings.TrimSpace(phrase)) == 0 {
+				err = fmt.Errorf("phrases for artist credits must not be empty")
+				return
+			}
+			quoted = append(quoted, regexp.QuoteMeta(strings.TrimSpace(phrase)))
+		}
+		return regexp.Compile(`(?i)\s+[(\[]?(?:` + strings.Join(quoted, "|") + `)\s+`)
+	}
+
+	if me.reFeat, err = compile(me.FeatPhrases); err != nil {
+		err = errors.Wrap(err, "feat phrases for artist credits are invalid")
+		return
+	}
+	if me.reJoin, err = compile(me.JoinPhrases); err != nil {
+		err = errors.Wrap(err, "join phrases for artist credits are invalid")
+		return
+	}
+	return
+}
+
+// Compilations contains the configuration of the handling of compilations.
+// Tracks are treated as compilations if their compilation flag is set. If the
+// flag is missing, compilations can be detected by their directories or by
+// the diversity of their track artists
+type Compilations struct {
+	// AlbumArtist is used as album artist for compilations that do not have
+	// one (e.g. "Various Artists")
+	AlbumArtist string `json:"album_artist"`
+	// SeparateContainer switches on that all compilations are put into one
+	// synthetic container in album artist levels of hierarchies. The name of
+	// that container is AlbumArtist
+	SeparateContainer bool `json:"separate_container"`
+	// Dirs contains directories. All tracks that are stored in these
+	// directories (or sub directories of them) are treated as compilations
+	Dirs []string `json:"dirs"`
+	// MinArtists is the minimum number of different track artists that an
+	// album without album artist must have in one directory to be treated as
+	// compilation. If it's 0, compilations are not detected this way
+	MinArtists int `json:"min_artists"`
+}
+
+// IsCompilationDir returns true if path is contained in one of the
+// compilation directories
+func (me *Compilations) IsCompilationDir(path string) bool {
+	for _, dir := range me.Dirs {
+		if isSub, _ := filepath.IsSub(dir, path); isSub {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks if the compilation configuration is correct. If it's not,
+// an error is returned
+func (me *Compilations) validate() (err error) {
+	for _, dir := range me.Dirs {
+		if !p.IsAbs(dir) {
+			err = fmt.Errorf("compilation directory '%s' is not an absolute path", dir)
+			return
+		}
+	}
+	if me.MinArtists < 0 {
+		err = fmt.Errorf("min_artists of compilations must be >= 0")
+		return
+	}
+	if me.SeparateContainer && len(me.AlbumArtist) == 0 {
+		err = fmt.Errorf("album_artist of compilations must be set if compilations shall be put into a separate container")
+		return
+	}
+	return
+}
+
+// fields that can be used in path templates. Besides the tags with multiple
+// values (see TagName), these are:
+const (
+	FieldAlbum = "album"
+	FieldDisc  = "disc"
+	FieldTitle = "title"
+	FieldTrack = "track"
+	FieldYear  = "year"
+)
+
+// modes of path templates
+const (
+	TemplateFill     = "fill"     // fill only tags that are missing
+	TemplateOverride = "override" // override tags
+)
+
+// reTemplateField matches fields in path templates, such as "%album%"
+var reTemplateField = regexp.MustCompile(`%([a-z]+)%`)
+
+// PathTemplate describes how tags are derived from the paths of the track files
+// of a music directory. A template consists of fields (e.g. "%album%") and
+// literal text, such as "%albumartist%/%year% - %album%/%track% - %title%".
+// It's matched against the end of the path of a track file (without
+// extension)
+type PathTemplate struct {
+	MusicDir string `json:"music_dir"`
+	Template string `json:"template"`
+	// Mode is either TemplateFill (default) or TemplateOverride
+	Mode   string `json:"mode"`
+	re     *regexp.Regexp
+	fields []string
+}
+
+// Match matches path against the template. path can be absolute or relative
+// to the music directory of the template. If path matches, the values of the
+// template fields are returned. Otherwise, ok is false
+func (me *PathTemplate) Match(path string) (values map[string]string, ok bool) {
+	if me.re == nil {
+		return
+	}
+	path = strings.TrimSuffix(path, p.Ext(path))
+	m := me.re.FindStringSubmatch(path)
+	if m == nil {
+		return
+	}
+	values = make(map[string]string)
+	for i, field := range me.fields {
+		values[field] = strings.TrimSpace(m[i+1])
+	}
+	return values, true
+}
+
+// Overrides returns true if the values derived from the path override the
+// values from the tags
+func (me *PathTemplate) Overrides() bool { return me.Mode == TemplateOverride }
+
+// validate checks if the path template is correct and compiles it into a
+// regular expression. If it's not correct, an error is returned
+func (me *PathTemplate) validate() (err error) {
+	if len(me.Template) == 0 {
+		err = fmt.Errorf("path template for music dir '%s' is empty", me.MusicDir)
+		return
+	}
+	if len(me.Mode) == 0 {
+		me.Mode = TemplateFill
+	}
+	if me.Mode != TemplateFill && me.Mode != TemplateOverride {
+		err = fmt.Errorf("path template for music dir '%s' has an invalid mode '%s'", me.MusicDir, me.Mode)
+		return
+	}
+
+	me.fields = []string{}
+	exists := make(map[string]struct{})
+	var expr strings.Builder
+	last := 0
+	for _, loc := range reTemplateField.FindAllStringSubmatchIndex(me.Template, -1) {
+		field := me.Template[loc[2]:loc[3]]
+		if _, ok := exists[field]; ok {
+			err = fmt.Errorf("path template '%s' contains field '%s' more than once", me.Template, field)
+			return
+		}
+		exists[field] = struct{}{}
+		expr.WriteString(regexp.QuoteMeta(me.Template[last:loc[0]]))
+		switch field {
+		case FieldDisc, FieldTrack, FieldYear:
+			expr.WriteString(`(\d+)`)
+		case FieldAlbum, FieldTitle, string(TagAlbumArtist), string(TagArtist), string(TagComposer), string(TagConductor), string(TagGenre), string(TagOrchestra), string(TagPerformer):
+			expr.WriteString(`([^/]+?)`)
+		default:
+			err = fmt.Errorf("path template '%s' contains unknown field '%s'", me.Template, field)
+			return
+		}
+		me.fields = append(me.fields, field)
+		last = loc[1]
+	}
+	expr.WriteString(regexp.QuoteMeta(me.Template[last:]))
+	if len(me.fields) == 0 {
+		err = fmt.Errorf("path template '%s' does not contain any field", me.Template)
+		return
+	}
+
+	if me.re, err = regexp.Compile(`(?:^|/)` + expr.String() + `$`); err != nil {
+		err = errors.Wrapf(err, "path template '%s' cannot be compiled", me.Template)
+		return
+	}
+	return
+}
+
+// default charset for text that has been detected as wrongly decoded
+const defaultRepairCharset = "windows-1251"
+
+// CharsetRepair contains the configuration of the repair of tags that have been
+// written in a legacy charset (e.g. CP1251) but that are decoded as if they
+// were written in another charset (e.g. Latin-1). Charsets are specified by
+// their IANA names
+type CharsetRepair struct {
+	// Detect switches on the heuristic detection of wrongly decoded text
+	Detect bool `json:"detect"`
+	// Charset is the charset that is assumed for wrongly decoded text that has
+	// been detected heuristically (and is not UTF-8). Default is windows-1251
+	Charset string `json:"charset"`
+	// Dirs maps directories to the charset of the tags of the tracks that are
+	// stored in these directories (or sub directories of them)
+	Dirs     map[string]string `json:"dirs"`
+	charset  encoding.Encoding
+	charsets map[string]encoding.Encoding
+}
+
+// IsActive returns true if texts shall be repaired
+func (me *CharsetRepair) IsActive() bool {
+	return me.Detect || len(me.Dirs) > 0
+}
+
+// DetectionCharset returns the charset that is assumed for wrongly decoded
+// text that has been detected heuristically
+func (me *CharsetRepair) DetectionCharset() encoding.Encoding { return me.charset }
+
+// DirCharset returns the charset that is configured for the directory that
+// path is stored in. If directories are nested, the innermost is taken. If
+// no charset is configured for path, nil is returned
+func (me *CharsetRepair) DirCharset(path string) (enc encoding.Encoding) {
+	var dir string
+	for d, e := range me.charsets {
+		if isSub, _ := filepath.IsSub(d, path); isSub && len(d) > len(dir) {
+			dir, enc = d, e
+		}
+	}
+	return
+}
+
+// validate checks if the charset repair configuration is correct and
+// determines the encodings of the configured charsets. If the configuration
+// is not correct, an error is returned
+func (me *CharsetRepair) validate() (err error) {
+	charset := func(name string) (enc encoding.Encoding, err error) {
+		if enc, err = ianaindex.IANA.Encoding(name); err != nil || enc == nil {
+			err = fmt.Errorf("charset '%s' is not supported", name)
+		}
+		return
+	}
+
+	name := me.Charset
+	if len(name) == 0 {
+		name = defaultRepairCharset
+	}
+	if me.charset, err = charset(name); err != nil {
+		return
+	}
+
+	me.charsets = make(map[string]encoding.Encoding)
+	for dir, name := range me.Dirs {
+		if !p.IsAbs(dir) {
+			err = fmt.Errorf("directory '%s' of charset repair is not an absolute path", dir)
+			return
+		}
+		if me.charsets[dir], err = charset(name); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// TagRules contains the rules that are applied to tag values before these are
+// added to the muserv content
+type TagRules struct {
+	// Aliases maps tag names to maps of aliases. Such a map maps an alias to
+	// the value that shall be used instead (e.g. "Hip Hop" -> "Hip-Hop"). The
+	// comparison of values and aliases is case-insensitive
+	Aliases map[TagName]map[string]string `json:"aliases"`
+	// Rewrites contains regular expressions that are applied to tag values in
+	// the given sequence
+	Rewrites []Rewrite `json:"rewrites"`
+	// ID3v1Genres switches on the translation of numeric ID3v1 genres (e.g.
+	// "(17)" -> "Rock"). It's switched on per default
+	ID3v1Genres *bool `json:"id3v1_genres"`
+	aliases     map[TagName]map[string]string
+}
+
+// Rewrite is a rule to rewrite the values of a tag. All matches of the regular
+// expression Regex are replaced by Replace. Replace can contain references to
+// submatches as described for regexp.Regexp.Expand
+type Rewrite struct {
+	Tag     TagName `json:"tag"`
+	Regex   string  `json:"regex"`
+	Replace string  `json:"replace"`
+	re      *regexp.Regexp
+}
+
+// Alias returns the value that shall be used instead of value v of tag tg. If
+// no alias is configured for v, v itself is returned. The alias maps are
+// assembled by validate, thus Alias can be called concurrently
+func (me *TagRules) Alias(tg TagName, v string) string {
+	if s, exists := me.aliases[tg][strings.ToLower(v)]; exists {
+		return s
+	}
+	return v
+}
+
+// Rewrite applies the rewrite rules that are configured for tag tg to v and
+// returns the result
+func (me *TagRules) Rewrite(tg TagName, v string) string {
+	for i := 0; i < len(me.Rewrites); i++ {
+		if me.Rewrites[i].Tag != tg || me.Rewrites[i].re == nil {
+			continue
+		}
+		v = me.Rewrites[i].re.ReplaceAllString(v, me.Rewrites[i].Replace)
+	}
+	return v
+}
+
+// assembleAliases creates the internal alias maps with lower case keys from
+// Aliases
+func (me *TagRules) assembleAliases() {
+	me.aliases = make(map[TagName]map[string]string)
+	for tg, aliases := range me.Aliases {
+		me.aliases[tg] = make(map[string]string)
+		for alias, v := range aliases {
+			me.aliases[tg][strings.ToLower(alias)] = v
+		}
+	}
+}
+
+// validate checks if the tag rules are correct and compiles the regular
+// expressions of the rewrite rules. If the rules are not correct, an error is
+// returned
+func (me *TagRules) validate() (err error) {
+	for tg := range me.Aliases {
+		if err = tg.IsValid(); err != nil {
+			err = errors.Wrap(err, "tag rules contain aliases for an invalid tag")
+			return
+		}
+	}
+	me.assembleAliases()
+
+	// the translation of ID3v1 genres is switched on per default
+	if me.ID3v1Genres == nil {
+		id3v1Genres := true
+		me.ID3v1Genres = &id3v1Genres
+	}
+
+	for i := 0; i < len(me.Rewrites); i++ {
+		if err = me.Rewrites[i].Tag.IsValid(); err != nil {
+			err = errors.Wrap(err, "tag rules contain a rewrite rule for an invalid tag")
+			return
+		}
+		if me.Rewrites[i].re, err = regexp.Compile(me.Rewrites[i].Regex); err != nil {
+			err = errors.Wrapf(err, "regular expression '%s' of rewrite rule for tag '%s' is invalid", me.Rewrites[i].Regex, me.Rewrites[i].Tag)
+			return
+		}
+	}
+
+	return
+}
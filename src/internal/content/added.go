@@ -0,0 +1,134 @@
+package content
+
+// this file contains the logic to determine when tracks have been added to the
+// content. In contrast to the time of the last change (which is taken from the
+// ctime of the track files and thus changes if tags are edited or if owner or
+// permissions are changed), this time is persisted in a cache file in the
+// cache directory when a track is seen for the first time
+
+import (
+	"encoding/json"
+	"os"
+	p "path"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// name of the cache file for the times when tracks have been added
+const addedCacheFile = "added.json"
+
+// addedCache contains the UNIX times when tracks have been added per track
+// file path
+type addedCache struct {
+	mu      sync.Mutex
+	path    string           // path of cache file
+	data    map[string]int64 // UNIX time when track was added per track file path
+	initial bool             // cache file didn't exist before
+	changed bool             // cache has changed since it was written
+}
+
+// newAddedCache creates a new cache for the times when tracks have been added
+// and reads the cache file from cacheDir (if it exists)
+func newAddedCache(cacheDir string) *addedCache {
+	ac := addedCache{
+		path: p.Join(cacheDir, addedCacheFile),
+		data: make(map[string]int64),
+	}
+
+	b, err := os.ReadFile(ac.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error(errors.Wrapf(err, "cannot read added cache '%s'", ac.path))
+		}
+		ac.initial = true
+		return &ac
+	}
+	if err = json.Unmarshal(b, &ac.data); err != nil {
+		log.Error(errors.Wrapf(err, "cannot parse added cache '%s'", ac.path))
+		ac.data = make(map[string]int64)
+		ac.initial = true
+	}
+
+	return &ac
+}
+
+// get returns the UNIX time when the track file path has been added. If the
+// track is not known yet, the birth time of the file is taken. If that's not
+// supported by the file system, the time of the last modification is taken
+// for the tracks that exist when the cache is created, and the current time
+// for all tracks that are added later
+func (me *addedCache) get(path string) int64 {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	if added, exists := me.data[path]; exists {
+		return added
+	}
+
+	added := time.Now().Unix()
+	var stx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, path, 0, unix.STATX_BTIME|unix.STATX_MTIME, &stx); err == nil {
+		switch {
+		case stx.Mask&unix.STATX_BTIME != 0 && stx.Btime.Sec > 0:
+			added = stx.Btime.Sec
+		case me.initial && stx.Mask&unix.STATX_MTIME != 0:
+			added = stx.Mtime.Sec
+		}
+	}
+	me.data[path] = added
+	me.changed = true
+
+	return added
+}
+
+// move transfers the time when the track file from has been added to to after
+// the file has been moved
+func (me *addedCache) move(from, to string) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	added, exists := me.data[from]
+	if !exists {
+		return
+	}
+	delete(me.data, from)
+	me.data[to] = added
+	me.changed = true
+}
+
+// prune removes the times of tracks that are no longer contained in tracks
+func (me *addedCache) prune(tracks tracks) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	for path := range me.data {
+		if _, exists := tracks[path]; !exists {
+			delete(me.data, path)
+			me.changed = true
+		}
+	}
+}
+
+// write writes the cache file if the cache has changed
+func (me *addedCache) write() {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	if !me.changed {
+		return
+	}
+	b, err := json.Marshal(me.data)
+	if err != nil {
+		log.Error(errors.Wrap(err, "cannot marshal added cache"))
+		return
+	}
+	if err = os.WriteFile(me.path, b, 0644); err != nil {
+		log.Error(errors.Wrapf(err, "cannot write added cache '%s'", me.path))
+		return
+	}
+	me.changed = false
+	me.initial = false
+}
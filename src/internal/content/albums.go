@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 
+	"gitlab.com/go-utilities/hash"
 	"gitlab.com/mipimipi/muserv/src/internal/config"
 )
 
@@ -11,12 +12,14 @@ import (
 // object exists
 type album struct {
 	*ctr
-	year        int
-	compilation bool
-	artists     []string    // album artists
-	composers   []string    // album composers
-	lastChange  int64       // UNIX time of last change of track file
-	refs        []*albumRef // corresponding album references
+	year         int
+	date         date // release date
+	originalDate date // original release date
+	compilation  bool
+	artists      []string    // album artists
+	composers    []string    // album composers
+	lastChange   int64       // UNIX time when the latest track was added
+	refs         []*albumRef // corresponding album references
 }
 
 // newAlbum creates a new album object
@@ -24,6 +27,8 @@ func newAlbum(cnt *Content, key uint64) (a *album) {
 	a = &album{
 		newCtr(cnt, cnt.newID(), ""),
 		0,
+		date{},
+		date{},
 		false,
 		[]string{},
 		[]string{},
@@ -39,8 +44,11 @@ func newAlbum(cnt *Content, key uint64) (a *album) {
 	return
 }
 
-// addChild adds a track as child and adjusts lastChange. If necessary, the
-// sorting of corresponding albumRefs is invalidated
+// addChild adds a track as child and adjusts lastChange. If necessary (i.e. if
+// lastChange or the rating of the album changed), the sorting of corresponding
+// albumRefs is invalidated. lastChange is based on the times when the tracks
+// have been added (and not on the ctime of the track files), so that the order
+// of albums doesn't change if tags are edited
 func (me *album) addChild(obj object) {
 	// only tracks can be added as children to album
 	if reflect.TypeOf(obj) != reflect.TypeOf((*track)(nil)) {
@@ -52,13 +60,16 @@ func (me *album) addChild(obj object) {
 	obj.setParent(me)
 	me.cnt.traceUpdate(me.i)
 
-	// if lastChange was adjusted, propagate the change to all albumRefs
+	// if lastChange or rating was adjusted, propagate the change to all
+	// albumRefs
 	t := obj.(*track)
-	if t.lastChange > me.lastChange {
-		me.lastChange = t.lastChange
-		for _, aRef := range me.refs {
-			aRef.parent().invalidateOrder()
-		}
+	changed := t.tags.rating > 0
+	if t.added > me.lastChange {
+		me.lastChange = t.added
+		changed = true
+	}
+	if changed {
+		me.invalidateRefOrder()
 	}
 }
 
@@ -70,52 +81,98 @@ func (me *album) delChild(obj object) {
 	obj.setParent(nil)
 	me.cnt.traceUpdate(me.i)
 
-	// adjust lastChange, propagate the change to all albumRefs if necessary
+	// adjust lastChange, propagate the change (or a change of the rating) to
+	// all albumRefs if necessary
 	t := obj.(*track)
-	if t.lastChange == me.lastChange {
+	if t.added == me.lastChange {
 		me.lastChange = 0
 		for i := 0; i < me.numChildren(); i++ {
-			t := me.childByIndex(i).(*track)
-			if t.lastChange > me.lastChange {
-				me.lastChange = t.lastChange
+			if t := me.childByIndex(i).(*track); t.added > me.lastChange {
+				me.lastChange = t.added
 			}
 		}
-		for _, aRef := range me.refs {
-			aRef.parent().invalidateOrder()
+		me.invalidateRefOrder()
+	} else if t.tags.rating > 0 {
+		me.invalidateRefOrder()
+	}
+}
+
+// isMultiDisc returns true if the album consists of more than one disc. That's
+// the case if its tracks have at least two different disc numbers. The total
+// number of discs from the tags is not taken into account, since for
+// incomplete albums, a single disc container would not be helpful
+func (me *album) isMultiDisc() bool {
+	discNo := -1
+	for _, obj := range me.children.byID {
+		t := obj.(*track)
+		if discNo >= 0 && t.tags.discNo != discNo {
+			return true
 		}
+		discNo = t.tags.discNo
 	}
+	return false
 }
 
-// newAlbumRef creates a new album reference object from an album
+// rating returns the rating of the album. That's the average rating of its
+// rated tracks. If none of its tracks is rated, 0 is returned
+func (me *album) rating() float64 {
+	var (
+		sum float64
+		n   int
+	)
+	for _, obj := range me.children.byID {
+		if r := obj.(*track).tags.rating; r > 0 {
+			sum += r
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	return sum / float64(n)
+}
+
+// sortValue returns the value of the album for sort field sf
+func (me *album) sortValue(sf config.SortField) (s string) {
+	switch sf {
+	case config.SortLastChange, config.SortAdded:
+		s = fmt.Sprintf("%020d", me.lastChange)
+	case config.SortTitle:
+		s = me.n
+	case config.SortYear:
+		s = fmt.Sprintf("%d", me.year)
+	case config.SortDate:
+		s = me.date.sortString()
+	case config.SortOriginalYear:
+		s = fmt.Sprintf("%04d", originalYear(me.originalDate, me.year))
+	case config.SortRating:
+		s = ratingSortString(me.rating())
+	}
+	return
+}
+
+// invalidateRefOrder repositions the references to the album in the
+// containers that contain them
+func (me *album) invalidateRefOrder() {
+	for _, aRef := range me.refs {
+		aRef.parent().reorderChild(aRef)
+	}
+}
+
+// newAlbumRef creates a new album reference object from an album. sfs are the
+// sort fields of the album level of the hierarchy
 func (me *album) newAlbumRef(sfs []config.SortField) *albumRef {
 	aRef := albumRef{
 		newCtr(me.cnt, me.cnt.newID(), me.n),
 		me,
+		sfs,
+		false,
 	}
 	aRef.marshalFunc = newAlbumRefMarshalFunc(aRef)
 	aRef.k = me.k
 
 	me.refs = append(me.refs, &aRef)
 
-	// set sort fields of album reference
-	if len(sfs) > 0 {
-		aRef.sf = []string{}
-		for _, sf := range sfs {
-			var s string
-			switch sf {
-			case config.SortLastChange:
-				s = fmt.Sprintf("%020d", me.lastChange)
-			case config.SortTitle:
-				s = me.n
-			case config.SortYear:
-				s = fmt.Sprintf("%d", me.year)
-			}
-			if len(s) > 0 {
-				aRef.sf = append(aRef.sf, s)
-			}
-		}
-	}
-
 	me.cnt.objects.add(aRef)
 
 	return &aRef
@@ -135,4 +192,72 @@ func (me albums) add(a *album) { me[a.key()] = a }
 type albumRef struct {
 	*ctr
 	album *album
+	sfs   []config.SortField // sort fields
+	discs bool               // children are disc containers
+}
+
+// sortField returns the value of sort field number i. Since the values of
+// some sort fields (such as lastChange or rating) change if tracks are added
+// to or removed from the album, they are determined from the album
+func (me albumRef) sortField(i int) string { return me.album.sortValue(me.sfs[i]) }
+
+// hasDiscs returns true if the children of the album reference are disc
+// containers (i.e. the album consists of multiple discs and the hierarchy
+// contains a disc level). Otherwise, the children are track references
+func (me *albumRef) hasDiscs() bool { return me.discs }
+
+// disc represents one disc of a multi-disc album. Disc containers are created
+// as children of album references if the hierarchy contains a disc level
+type disc struct {
+	*ctr
+	album *album
+	no    int // disc number
+}
+
+// newDisc creates a new disc container for the disc of track t. If t has a disc
+// subtitle, that's used as name of the disc. sfs are the sort fields of the
+// disc level of the hierarchy
+func newDisc(cnt *Content, a *album, t *track, sfs []config.SortField) (d *disc) {
+	d = &disc{
+		newCtr(cnt, cnt.newID(), discName(t)),
+		a,
+		t.tags.discNo,
+	}
+	d.k = discKey(t.tags.discNo)
+	d.marshalFunc = newDiscMarshalFunc(d, cnt.extPicturePath)
+
+	// set sort fields of disc
+	if len(sfs) > 0 {
+		d.sf = []string{}
+		for _, sf := range sfs {
+			var s string
+			switch sf {
+			case config.SortDiscNo:
+				s = fmt.Sprintf("%03d", d.no)
+			case config.SortTitle:
+				s = d.n
+			}
+			if len(s) > 0 {
+				d.sf = append(d.sf, s)
+			}
+		}
+	}
+
+	cnt.objects.add(d)
+
+	return
+}
+
+// discKey calculates the key of a disc container from the disc number
+func discKey(no int) uint64 {
+	return hash.HashUint64("disc%d", no)
+}
+
+// discName returns the name of the disc that track t belongs to. That's the
+// disc subtitle if it's set, "Disc <NUMBER>" otherwise
+func discName(t *track) string {
+	if len(t.tags.discSubtitle) > 0 {
+		return t.tags.discSubtitle
+	}
+	return fmt.Sprintf("Disc %d", t.tags.discNo)
 }
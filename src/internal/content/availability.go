@@ -0,0 +1,145 @@
+package content
+
+// this file contains the logic to detect music directories that are not
+// available (e.g. since the NFS or SMB share behind them is not mounted). The
+// content of such directories is kept until they are available again. A music
+// directory is regarded as unavailable if it cannot be read, if it has been a
+// mount point before but isn't anymore, or if it's empty though the content
+// contains tracks from it
+
+import (
+	"io"
+	"os"
+	p "path"
+	"sort"
+	"sync"
+	"syscall"
+)
+
+// availability keeps track of the availability of the music directories
+type availability struct {
+	mu          sync.Mutex
+	mountPoints map[string]struct{} // music dirs that have been mount points
+	unavailable map[string]string   // reason per unavailable music dir
+	resumed     map[string]struct{} // music dirs that are available again
+}
+
+// newAvailability creates a new availability instance
+func newAvailability() *availability {
+	return &availability{
+		mountPoints: make(map[string]struct{}),
+		unavailable: make(map[string]string),
+		resumed:     make(map[string]struct{}),
+	}
+}
+
+// isMountPoint returns true if dir is a mount point, i.e. if it's on another
+// device than its parent directory
+func isMountPoint(dir string) bool {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return false
+	}
+	parent, err := os.Stat(p.Dir(dir))
+	if err != nil {
+		return false
+	}
+	return info.Sys().(*syscall.Stat_t).Dev != parent.Sys().(*syscall.Stat_t).Dev
+}
+
+// isEmptyDir returns true if the directory dir has no entries
+func isEmptyDir(dir string) (bool, error) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return false, err
+	}
+	defer d.Close()
+	if _, err = d.Readdirnames(1); err == io.EOF {
+		return true, nil
+	}
+	return false, err
+}
+
+// check checks if the music dir is available. hasTracks must be true if the
+// content contains tracks from dir. Changes of the availability are logged
+func (me *availability) check(dir string, hasTracks bool) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	var reason string
+	empty, err := isEmptyDir(dir)
+	if err != nil {
+		reason = err.Error()
+	} else if isMountPoint(dir) {
+		me.mountPoints[dir] = struct{}{}
+	} else if _, exists := me.mountPoints[dir]; exists {
+		reason = "it's not mounted"
+	}
+	if len(reason) == 0 && empty && hasTracks {
+		reason = "it's empty"
+	}
+
+	_, wasUnavailable := me.unavailable[dir]
+	if len(reason) > 0 {
+		if !wasUnavailable {
+			log.Errorf("music dir '%s' is unavailable (%s): its content is kept until it's available again", dir, reason)
+		}
+		me.unavailable[dir] = reason
+		delete(me.resumed, dir)
+		return false
+	}
+	if wasUnavailable {
+		log.Infof("music dir '%s' is available again", dir)
+		delete(me.unavailable, dir)
+		me.resumed[dir] = struct{}{}
+	}
+	return true
+}
+
+// availableDirs checks the availability of the music dirs dirs and returns
+// those that are available. hasFiles is used to determine if the content
+// contains files of a music dir
+func (me *availability) availableDirs(dirs []string, hasFiles func(string) bool) (available []string) {
+	for _, dir := range dirs {
+		if me.check(dir, hasFiles(dir)) {
+			available = append(available, dir)
+		}
+	}
+	return
+}
+
+// isAvailable returns the availability of the music dir as determined by the
+// last check
+func (me *availability) isAvailable(dir string) bool {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	_, unavailable := me.unavailable[dir]
+	return !unavailable
+}
+
+// takeResumed returns the music dirs that are available again since the last
+// call
+func (me *availability) takeResumed() (dirs []string) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	for dir := range me.resumed {
+		dirs = append(dirs, dir)
+	}
+	me.resumed = make(map[string]struct{})
+	sort.Strings(dirs)
+	return
+}
+
+// unavailableDirs returns the unavailable music dirs and the reasons
+func (me *availability) unavailableDirs() map[string]string {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	dirs := make(map[string]string, len(me.unavailable))
+	for dir, reason := range me.unavailable {
+		dirs[dir] = reason
+	}
+	return dirs
+}
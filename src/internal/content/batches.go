@@ -0,0 +1,162 @@
+package content
+
+import (
+	"os"
+	p "path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/rjeczalik/notify"
+)
+
+// batchCheckInterval is the interval in which the batches are checked whether
+// they are ready to be processed
+const batchCheckInterval = time.Second
+
+// batch contains the changes in a directory that are processed together
+type batch struct {
+	first   time.Time // time of the first change
+	last    time.Time // time of the last change
+	changes []notify.EventInfo
+}
+
+// fileState is the size and the time of the last change of a file that is
+// still being written
+type fileState struct {
+	size  int64
+	ctime time.Time
+	since time.Time // time since when the file has that state
+}
+
+// batches collects the changes that have been observed by inotify per
+// directory. A batch is ready to be processed if there were no changes in its
+// directory for the quiet period, but at latest after the maximum delay. Files
+// that are still being written (i.e. whose size or time of last change are not
+// stable) are deferred
+type batches struct {
+	mu       sync.Mutex
+	quiet    time.Duration
+	maxDelay time.Duration
+	dirs     map[string]*batch
+	files    map[string]fileState // files that are still being written
+}
+
+// newBatches creates a new batches instance
+func newBatches(quiet, maxDelay time.Duration) *batches {
+	return &batches{
+		quiet:    quiet,
+		maxDelay: maxDelay,
+		dirs:     make(map[string]*batch),
+		files:    make(map[string]fileState),
+	}
+}
+
+// add adds the change chg that was observed at time now to the batch of its
+// directory
+func (me *batches) add(chg notify.EventInfo, now time.Time) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	dir := p.Dir(chg.Path())
+	b, exists := me.dirs[dir]
+	if !exists {
+		b = &batch{first: now}
+		me.dirs[dir] = b
+	}
+	b.last = now
+	b.changes = append(b.changes, chg)
+}
+
+// reset removes all changes
+func (me *batches) reset() {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	me.dirs = make(map[string]*batch)
+	me.files = make(map[string]fileState)
+}
+
+// ready removes the batches that are ready to be processed at time now and
+// returns their changes. A batch is not ready (unless the maximum delay is
+// exceeded) as long as there are batches for sub directories that are not
+// ready, since the processing of a directory comprises its sub directories.
+// Changes of files that are still being written are kept
+func (me *batches) ready(now time.Time) (changes []notify.EventInfo) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	// evaluate sub directories before their parents
+	dirs := make([]string, 0, len(me.dirs))
+	for dir := range me.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+
+	pending := make(map[string]struct{})
+	for _, dir := range dirs {
+		b := me.dirs[dir]
+		if now.Sub(b.first) < me.maxDelay {
+			if now.Sub(b.last) < me.quiet || hasSubDir(pending, dir) {
+				pending[dir] = struct{}{}
+				continue
+			}
+		}
+
+		var deferred []notify.EventInfo
+		for _, chg := range b.changes {
+			if me.isGrowing(chg.Path(), now) {
+				deferred = append(deferred, chg)
+				continue
+			}
+			changes = append(changes, chg)
+		}
+		if len(deferred) > 0 {
+			b.changes = deferred
+			pending[dir] = struct{}{}
+			continue
+		}
+		delete(me.dirs, dir)
+	}
+	return
+}
+
+// hasSubDir returns true if dirs contains a sub directory of dir
+func hasSubDir(dirs map[string]struct{}, dir string) bool {
+	for d := range dirs {
+		if strings.HasPrefix(d, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// isGrowing returns true if the file path is still being written at time now.
+// That's the case if its time of last change is within the quiet period and
+// its size or time of last change have changed within the quiet period
+func (me *batches) isGrowing(path string, now time.Time) bool {
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		delete(me.files, path)
+		return false
+	}
+	ctim := info.Sys().(*syscall.Stat_t).Ctim
+	size, ctime := info.Size(), time.Unix(int64(ctim.Sec), int64(ctim.Nsec))
+	if now.Sub(ctime) >= me.quiet {
+		delete(me.files, path)
+		return false
+	}
+
+	fs, exists := me.files[path]
+	if !exists || fs.size != size || !fs.ctime.Equal(ctime) {
+		me.files[path] = fileState{size: size, ctime: ctime, since: now}
+		return true
+	}
+	if now.Sub(fs.since) >= me.quiet {
+		delete(me.files, path)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,111 @@
+package content
+
+// this file contains the logic to repair tag values that have been written in a
+// legacy charset (e.g. CP1251) or in UTF-8, but that have been decoded as if
+// they were Latin-1. That happens for tags of old Windows rippers, for
+// example. Package tag decodes ID3v1 tags and ID3v2 text frames with encoding
+// ISO-8859-1 byte by byte. Thus, the original bytes can be restored
+
+import (
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dhowden/tag"
+	"gitlab.com/mipimipi/muserv/src/internal/config"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// isLegacyFormat returns true if tags of format f can contain text in a legacy
+// charset
+func isLegacyFormat(f tag.Format) bool {
+	return f == tag.ID3v1 || f == tag.ID3v2_2 || f == tag.ID3v2_3
+}
+
+// repairCharset repairs s if it has been decoded with the wrong charset. path
+// is the path of the track file. If a charset is configured for its directory,
+// the text is decoded with that charset. Otherwise (and if that's configured)
+// wrongly decoded text is detected heuristically. repaired is true if s has
+// been changed
+func repairCharset(cr *config.CharsetRepair, path, s string) (result string, repaired bool) {
+	b, ok := originalBytes(s)
+	if !ok {
+		return s, false
+	}
+
+	var enc encoding.Encoding
+	switch {
+	case cr.DirCharset(path) != nil:
+		enc = cr.DirCharset(path)
+	case !cr.Detect:
+		return s, false
+	case utf8.Valid(b):
+		// UTF-8 that has been decoded as Latin-1
+		return string(b), true
+	case looksLikeLegacyText(b):
+		enc = cr.DetectionCharset()
+	default:
+		return s, false
+	}
+
+	result, err := enc.NewDecoder().String(string(b))
+	if err != nil || result == s {
+		return s, false
+	}
+	return result, true
+}
+
+// isSuspiciousText returns true if s contains characters that indicate that it
+// has been decoded with the wrong charset
+func isSuspiciousText(s string) bool {
+	for _, r := range s {
+		// replacement character or C1 control characters
+		if r == utf8.RuneError || (r >= 0x80 && r <= 0x9f) {
+			return true
+		}
+	}
+	if b, ok := originalBytes(s); ok {
+		return utf8.Valid(b) || looksLikeLegacyText(b)
+	}
+	return false
+}
+
+// originalBytes restores the bytes that s has been decoded from (assuming it
+// has been decoded as Latin-1 or Windows-1252). ok is false if s is pure
+// ASCII or if it contains characters that cannot be the result of such a
+// decoding
+func originalBytes(s string) (b []byte, ok bool) {
+	for _, r := range s {
+		if r >= utf8.RuneSelf {
+			ok = true
+		}
+		if r < 0x100 {
+			b = append(b, byte(r))
+			continue
+		}
+		c, isCP1252 := charmap.Windows1252.EncodeRune(r)
+		if !isCP1252 {
+			return nil, false
+		}
+		b = append(b, c)
+	}
+	return
+}
+
+// looksLikeLegacyText returns true if b is probably text in a legacy charset
+// with non-Latin letters (such as Cyrillic in CP1251). In Latin-1, the bytes
+// from 0xc0 on are mostly accented letters. Latin texts contain such letters
+// only occasionally. If they make up the majority of the letters, the text is
+// most likely not Latin-1
+func looksLikeLegacyText(b []byte) bool {
+	var high, ascii int
+	for _, c := range b {
+		switch {
+		case c >= 0xc0 && c != 0xd7 && c != 0xf7:
+			high++
+		case c < utf8.RuneSelf && unicode.IsLetter(rune(c)):
+			ascii++
+		}
+	}
+	return high >= 2 && high > ascii
+}
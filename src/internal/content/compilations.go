@@ -0,0 +1,126 @@
+package content
+
+import (
+	p "path"
+)
+
+// dirArtists maps album titles to the track artists of the tracks of that
+// album that are stored in one directory and that do not have an album artist
+type dirArtists map[string]map[string]struct{}
+
+// add adds the track artists artists of a track of album album
+func (me dirArtists) add(album string, artists []string) {
+	if _, exists := me[album]; !exists {
+		me[album] = make(map[string]struct{})
+	}
+	for _, artist := range artists {
+		if len(artist) > 0 {
+			me[album][artist] = struct{}{}
+		}
+	}
+}
+
+// completeAlbumArtists detects if the track with path path and tags tgs belongs
+// to a compilation (in case the compilation flag is neither set nor forced to
+// false) and completes the album artists: For compilations without album
+// artist, the configured album artist for compilations is taken, for other
+// albums the track artists
+func (me *Content) completeAlbumArtists(path string, tgs *tags) {
+	cfg := &me.cfg.Cnt.Compilations
+
+	if !tgs.compilation && !tgs.compForced && len(tgs.album) > 0 {
+		switch {
+		case cfg.IsCompilationDir(path):
+			tgs.compilation = true
+		case cfg.MinArtists > 0 && isEmpty(tgs.albumArtists):
+			da := me.dirArtists(p.Dir(path))
+			da.add(tgs.album, tgs.artists)
+			tgs.compilation = len(da[tgs.album]) >= cfg.MinArtists
+			tgs.compDetected = true
+		}
+	}
+
+	if !isEmpty(tgs.albumArtists) {
+		return
+	}
+	if !tgs.compilation {
+		tgs.albumArtists = tgs.artists
+		return
+	}
+	if len(cfg.AlbumArtist) > 0 {
+		tgs.albumArtists = []string{cfg.AlbumArtist}
+	}
+}
+
+// dirArtists returns the track artists per album of the tracks in directory dir
+// for which compilations are detected by the number of track artists (i.e. of
+// the tracks without album artist and compilation flag). The artists are taken
+// from the tracks of the content, so that the track files are not read again.
+// Since tracks that are added later on can change the result, the tracks of
+// the changed directories are re-evaluated at the end of a content update (see
+// redetectCompilations)
+func (me *Content) dirArtists(dir string) dirArtists {
+	da := make(dirArtists)
+	me.paths.filesIn(dir, func(path string, kind infoKind) {
+		if kind != infoTrack {
+			return
+		}
+		if t, exists := me.tracks[path]; exists && t.tags.compDetected {
+			da.add(t.tags.album, t.tags.artists)
+		}
+	})
+	return da
+}
+
+// redetectCompilations re-evaluates the compilation flag of the tracks that
+// are stored in dirs and for which it's detected by the number of track
+// artists. That's necessary since tracks might have been added to or removed
+// from these directories after the tracks have been read. Tracks whose flag
+// changes are re-added to their album and the hierarchies
+func (me *Content) redetectCompilations(count *uint32, dirs map[string]struct{}) (err error) {
+	minArtists := me.cfg.Cnt.Compilations.MinArtists
+
+	for dir := range dirs {
+		da := me.dirArtists(dir)
+		var changed []*track
+		me.paths.filesIn(dir, func(path string, kind infoKind) {
+			if kind != infoTrack {
+				return
+			}
+			t, exists := me.tracks[path]
+			if !exists || !t.tags.compDetected {
+				return
+			}
+			if t.tags.compilation != (len(da[t.tags.album]) >= minArtists) {
+				changed = append(changed, t)
+			}
+		})
+
+		for _, t := range changed {
+			log.Tracef("compilation flag of track '%s' changes to %t", t.path, !t.tags.compilation)
+
+			me.detachTrack(count, t, false)
+			t.tags.compilation, t.tags.albumArtists = false, []string{}
+			me.completeAlbumArtists(t.path, t.tags)
+			// count change of track object
+			*count++
+
+			me.addTrackToAlbum(count, t)
+			if err = me.addTrackToHierarchies(count, t); err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// isEmpty returns true if entries does not contain a non-empty value
+func isEmpty(entries []string) bool {
+	for _, entry := range entries {
+		if len(entry) > 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -7,11 +7,12 @@ import (
 	"net/url"
 	"path"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/pkg/errors"
 	l "github.com/sirupsen/logrus"
-	"gitlab.com/go-utilities/filepath"
 	"gitlab.com/go-utilities/net"
 	"gitlab.com/mipimipi/muserv/src/internal/config"
 	"golang.org/x/text/language"
@@ -31,6 +32,14 @@ const (
 const (
 	MusicFolder   = "/music/"
 	PictureFolder = "/pictures/"
+	LyricsFolder  = "/lyrics/"
+)
+
+// mime types of lyrics: unsynchronized lyrics are provided as plain text,
+// time-synced lyrics in LRC format
+const (
+	LyricsMimeType = "text/plain"
+	LRCMimeType    = "text/x-lrc"
 )
 
 // status implements the content status
@@ -71,7 +80,18 @@ type Content struct {
 	cfg            *config.Cfg      // muserv configuration
 	extMusicPath   string           // external, virtual music path
 	extPicturePath string           // external, virtual picture path
+	extLyricsPath  string           // external, virtual lyrics path
 	updCounts      map[ObjID]uint32 // update counter per container object
+	loudnessCache  *loudnessCache   // loudness measurements (nil if not configured)
+	addedCache     *addedCache      // times when tracks have been added
+	overrides      *tagOverrides    // tag overrides (see overrides.go)
+	rules          *dirRules        // directory rules (see dirrules.go)
+	links          *symlinks        // followed symbolic links (nil if links are not followed)
+	avail          *availability    // availability of the music directories
+	ioLimit        *rateLimiter     // limits the rate in which track files are read (nil if not limited)
+	paths          *pathIndex       // index of the paths of tracks and playlists
+	nfos           map[string]*nfo  // cache of .nfo files
+	artistNFOs     map[string]*nfo  // artist.nfo data per artist name (lower case)
 }
 
 // New creats a new Content instance
@@ -85,7 +105,7 @@ func New(cfg *config.Cfg) (cnt *Content, err error) {
 		return
 	}
 
-	// assemble URLs for music and pictures
+	// assemble URLs for music, pictures and lyrics
 	musicURL := url.URL{
 		Scheme: "http",
 		Path:   MusicFolder,
@@ -94,12 +114,18 @@ func New(cfg *config.Cfg) (cnt *Content, err error) {
 		Scheme: "http",
 		Path:   PictureFolder,
 	}
+	lyricsURL := url.URL{
+		Scheme: "http",
+		Path:   LyricsFolder,
+	}
 	if cfg.UPnP.Port == 0 {
 		musicURL.Host = addr.String()
 		pictureURL.Host = addr.String()
+		lyricsURL.Host = addr.String()
 	} else {
 		musicURL.Host = fmt.Sprintf("%s:%d", addr.String(), cfg.UPnP.Port)
 		pictureURL.Host = fmt.Sprintf("%s:%d", addr.String(), cfg.UPnP.Port)
+		lyricsURL.Host = fmt.Sprintf("%s:%d", addr.String(), cfg.UPnP.Port)
 
 	}
 
@@ -107,16 +133,29 @@ func New(cfg *config.Cfg) (cnt *Content, err error) {
 		objects:        make(objects),
 		albums:         make(albums),
 		folders:        make(folders),
-		pictures:       pictures{data: make(map[uint64]*[]byte)},
+		pictures:       pictures{data: make(map[uint64]*[]byte), workers: make(chan struct{}, cfg.Cnt.PictureWorkers)},
 		playlists:      make(playlists),
 		tracks:         make(tracks),
+		paths:          newPathIndex(),
 		newID:          idGenerator(),
 		cfg:            cfg,
 		extMusicPath:   musicURL.String(),
 		extPicturePath: pictureURL.String(),
+		extLyricsPath:  lyricsURL.String(),
 		updCounts:      make(map[ObjID]uint32),
+		nfos:           make(map[string]*nfo),
+		artistNFOs:     make(map[string]*nfo),
+	}
+	if cfg.Cnt.LoudnessScan {
+		cnt.loudnessCache = newLoudnessCache(cfg.CacheDir)
 	}
-	cnt.updater = newUpdater(cfg.Cnt.UpdateMode, cnt.filesByPaths, cnt.update)
+	cnt.addedCache = newAddedCache(cfg.CacheDir)
+	cnt.overrides = newTagOverrides(cfg)
+	cnt.rules = newDirRules(cfg)
+	cnt.links = newSymlinks(cfg)
+	cnt.avail = newAvailability()
+	cnt.ioLimit = newRateLimiter(cfg)
+	cnt.updater = newUpdater(cfg.Cnt.UpdateMode, cnt)
 
 	// create the root object and its direct children (the hierarchy containers)
 	cnt.makeTree()
@@ -201,13 +240,48 @@ func (me *Content) InitialUpdate(ctx context.Context) (err error) {
 	cfg := ctx.Value(config.KeyCfg).(config.Cfg)
 
 	// get changes that must be applied to content
-	tDel, tAdd := fullScan(cfg.Cnt.MusicDirs, me.filesByPaths)
+	tDel, tAdd := me.fullScan(cfg.Cnt.MusicDirs)
 
 	// update content
 	_, err = me.update(ctx, tDel, tAdd)
 	return
 }
 
+// Lyrics returns the lyrics of the track with the object id id. If synced is
+// true, the content of the .lrc file of the track is returned. Otherwise, the
+// unsynchronized lyrics are returned. These are taken from the tags of the
+// track or - if there are none - from the .lrc file (without time tags). An
+// error is returned if there are no lyrics
+func (me *Content) Lyrics(id uint64, synced bool) (string, error) {
+	obj, exists := me.objects[ObjID(id)]
+	if !exists {
+		return "", fmt.Errorf("an object with id %d could not be found", id)
+	}
+	t, ok := obj.(*track)
+	if !ok || !t.hasLyrics() || (synced && !t.hasLRC) {
+		return "", fmt.Errorf("there are no lyrics for object %d", id)
+	}
+
+	// the lyrics from the tags are not kept in memory but read on demand
+	if !synced && t.tags.hasLyrics {
+		lyrics, err := readTagLyrics(t.path)
+		if err != nil {
+			return "", errors.Wrapf(err, "cannot read lyrics of track '%s'", t.path)
+		}
+		if len(lyrics) > 0 || !t.hasLRC {
+			return lyrics, nil
+		}
+	}
+	lrc, err := readLRC(t.path)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot read lyrics of track '%s'", t.path)
+	}
+	if synced {
+		return lrc, nil
+	}
+	return unsyncLRC(lrc), nil
+}
+
 // Picture returns the picture with the given ID. If it doesn't exist, nil is
 // returned
 func (me *Content) Picture(id uint64) *[]byte {
@@ -222,6 +296,11 @@ func (me *Content) ResetCtrUpdCounts() {
 
 // Run starts the regular content updates
 func (me *Content) Run(ctx context.Context, wg *sync.WaitGroup) {
+	// measure loudness of tracks in the background
+	if me.loudnessCache != nil {
+		wg.Add(1)
+		go me.loudnessCache.run(ctx, wg, me.ioLimit)
+	}
 	me.updater.run(ctx, wg)
 	me.status.overall = statusRunning
 }
@@ -252,6 +331,19 @@ func (me *Content) WriteStatus(w io.Writer) {
 		fmt.Fprintf(w, "    %6d tracks\n", len(me.tracks))
 		fmt.Fprintf(w, "    %6d albums\n", len(me.albums))
 		fmt.Fprintf(w, "    %6d playlists\n\n", len(me.playlists))
+		// music dirs that are not available
+		if unavailable := me.avail.unavailableDirs(); len(unavailable) > 0 {
+			fmt.Fprint(w, "    Unavailable music dirs:\n")
+			dirs := make([]string, 0, len(unavailable))
+			for dir := range unavailable {
+				dirs = append(dirs, dir)
+			}
+			sort.Strings(dirs)
+			for _, dir := range dirs {
+				fmt.Fprintf(w, "    %s (%s)\n", dir, unavailable[dir])
+			}
+			fmt.Fprint(w, "\n")
+		}
 		// memory consumption
 		var m runtime.MemStats
 		runtime.ReadMemStats(&m)
@@ -271,27 +363,22 @@ func (me *Content) WriteStatus(w io.Writer) {
 }
 
 // filesByPaths returns all files (i.e. tracks and playlists) whose filepath
-// begins with a path from paths
+// begins with a path from paths. The files are sorted by path
 func (me *Content) filesByPaths(paths []string) *fileInfos {
 	var fis fileInfos
-	for p, fi := range me.tracks {
-	L0:
-		for _, path := range paths {
-			if isSub, _ := filepath.IsSub(p, path); isSub {
-				fis = append(fis, newTrackInfo(p, fi.lastChange))
-				break L0
+	for _, path := range paths {
+		me.paths.below(path, func(path string, kind infoKind) {
+			switch kind {
+			case infoTrack:
+				fis = append(fis, newTrackInfo(me, path, me.tracks[path].fileChange))
+			case infoPlaylist:
+				fis = append(fis, newPlaylistInfo(path, me.playlists[path].lastChange))
 			}
-		}
-	}
-	for p, fi := range me.playlists {
-	L1:
-		for _, path := range paths {
-			if isSub, _ := filepath.IsSub(path, p); isSub {
-				fis = append(fis, newPlaylistInfo(p, fi.lastChange))
-				break L1
-			}
-		}
+		})
 	}
+	// paths can overlap
+	sort.Sort(fis)
+	fis.removeDuplicates()
 	return &fis
 }
 
@@ -310,26 +397,73 @@ func (me *Content) update(ctx context.Context, fiDel, fiAdd *fileInfos) (count u
 	// initialize container update counter
 	me.updCounts = make(map[ObjID]uint32)
 
+	// moved tracks keep their track objects (see moves.go)
+	moves := me.detectMoves(fiDel, fiAdd)
+
+	// directories to which tracks are added or from which tracks are removed.
+	// For the tracks in these directories, compilations are detected again
+	// (see compilations.go)
+	var dirs map[string]struct{}
+	if me.cfg.Cnt.Compilations.MinArtists > 0 {
+		dirs = make(map[string]struct{})
+		for _, fis := range []*fileInfos{fiDel, fiAdd} {
+			for _, fi := range *fis {
+				if fi.kind() == infoTrack {
+					dirs[path.Dir(fi.path())] = struct{}{}
+				}
+			}
+		}
+		for _, m := range moves {
+			dirs[path.Dir(m.t.path)] = struct{}{}
+			dirs[path.Dir(m.ti.path())] = struct{}{}
+		}
+	}
+
 	// delete files
-	if err = me.procUpdates(ctx, &count, fiDel,
+	if err = me.procUpdates(ctx, &count, fiDel, false,
 		func(wg *sync.WaitGroup, count *uint32, pli playlistInfo) error { return me.delPlaylist(wg, count, pli) },
 		func(wg *sync.WaitGroup, count *uint32, ti trackInfo) error { return me.delTrack(wg, count, ti) },
 	); err != nil {
 		return
 	}
 
+	// move tracks
+	if len(moves) > 0 {
+		log.Tracef("processing %d moved tracks ...", len(moves))
+		var wg sync.WaitGroup
+		for _, m := range moves {
+			if err = me.moveTrack(&wg, &count, m.t, m.ti); err != nil {
+				log.Fatal(err)
+			}
+		}
+		wg.Wait()
+	}
+
 	// add files
-	if err = me.procUpdates(ctx, &count, fiAdd,
+	if err = me.procUpdates(ctx, &count, fiAdd, true,
 		func(wg *sync.WaitGroup, count *uint32, pli playlistInfo) error { return me.addPlaylist(wg, count, pli) },
 		func(wg *sync.WaitGroup, count *uint32, ti trackInfo) error { return me.addTrack(wg, count, ti) },
 	); err != nil {
 		return
 	}
 
+	// re-evaluate compilations that are detected by the number of artists
+	if err = me.redetectCompilations(&count, dirs); err != nil {
+		return
+	}
+
 	// remove obsolete objects such as cover pictures that are no longer
 	// required
 	me.cleanup()
 
+	// persist the times when tracks have been added. If the update has been
+	// interrupted, the times of deleted tracks are kept since they might be
+	// added again in the next update
+	if ctx.Err() == nil {
+		me.addedCache.prune(me.tracks)
+	}
+	me.addedCache.write()
+
 	// set status
 	me.status.overall = statusRunning
 
@@ -378,20 +512,46 @@ func (me *Content) makeTree() {
 
 // cleanup removes obsolete onjects
 func (me *Content) cleanup() {
-	// remove obsolete pictures from picture map
+	// remove obsolete pictures from picture map. Besides the pictures of the
+	// tracks, the thumbnails from .nfo files are kept
 	newPics := make(map[uint64]*[]byte)
+	keep := func(picID nonePicID) {
+		if picID.valid && me.pictures.data[picID.id] != nil {
+			newPics[picID.id] = me.pictures.data[picID.id]
+		}
+	}
+	// remove obsolete .nfo files from the cache and assign the artist.nfo
+	// data to the artist names
+	nfos := make(map[*nfo]struct{})
+	me.artistNFOs = make(map[string]*nfo)
 	for _, t := range me.tracks {
-		if !t.picID.valid {
-			continue
+		keep(t.picID)
+		if t.albumNFO != nil {
+			keep(t.albumNFO.picID)
+			nfos[t.albumNFO] = struct{}{}
 		}
-		if me.pictures.data[t.picID.id] != nil {
-			newPics[t.picID.id] = me.pictures.data[t.picID.id]
+		if t.artistNFO != nil {
+			keep(t.artistNFO.picID)
+			nfos[t.artistNFO] = struct{}{}
+			me.artistNFOs[strings.ToLower(t.artistNFO.name)] = t.artistNFO
 		}
 	}
 	me.pictures.data = newPics
+	for path, n := range me.nfos {
+		if _, used := nfos[n]; !used {
+			delete(me.nfos, path)
+		}
+	}
 }
 
-func (me *Content) procUpdates(ctx context.Context, count *uint32, fis *fileInfos,
+// readAheadFactor is the number of tracks per worker whose metadata is read
+// ahead of processing them
+const readAheadFactor = 4
+
+// procUpdates processes the file infos fis with procPlaylistUpdate and
+// procTrackUpdate. If readAhead is true, the metadata of the tracks is read
+// ahead concurrently
+func (me *Content) procUpdates(ctx context.Context, count *uint32, fis *fileInfos, readAhead bool,
 	procPlaylistUpdate func(*sync.WaitGroup, *uint32, playlistInfo) error,
 	procTrackUpdate func(*sync.WaitGroup, *uint32, trackInfo) error) (err error) {
 	if len(*fis) == 0 {
@@ -405,12 +565,35 @@ func (me *Content) procUpdates(ctx context.Context, count *uint32, fis *fileInfo
 	me.status.update.task = "processing updates"
 	me.status.update.total = len(*fis)
 
-	fInfos := make(chan fileInfo)
+	// the file infos are processed one after the other, but the metadata of
+	// the tracks is read ahead by a pool of workers. The number of tracks that
+	// are read ahead is limited to keep the memory consumption (pictures!) low
+	fInfos := make(chan fileInfo, readAheadFactor*me.cfg.Cnt.Workers)
+	metas := make(chan trackInfo)
+	for i := 0; i < me.cfg.Cnt.Workers; i++ {
+		go func() {
+			for ti := range metas {
+				ti.readAhead(me)
+			}
+		}()
+	}
 	go func() {
+		defer close(metas)
+		defer close(fInfos)
 		for _, fi := range *fis {
-			fInfos <- fi
+			if readAhead && fi.kind() == infoTrack {
+				select {
+				case metas <- fi.(trackInfo):
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case fInfos <- fi:
+			case <-ctx.Done():
+				return
+			}
 		}
-		close(fInfos)
 	}()
 
 	var wg sync.WaitGroup
@@ -477,6 +660,7 @@ func (me *Content) delPlaylist(wg *sync.WaitGroup, count *uint32, pli playlistIn
 	*count++
 	// remove from playlists
 	delete(me.playlists, pli.path())
+	me.paths.del(pli.path())
 	// remove from objects
 	delete(me.objects, pl.id())
 	// remove from hierarchies
@@ -501,9 +685,12 @@ func (me *Content) addTrack(wg *sync.WaitGroup, count *uint32, ti trackInfo) (er
 		log.Fatal(err)
 		return err
 	}
+	return me.addTrackToHierarchies(count, t)
+}
 
-	// add t to all configured hierarchies and (if configured) the folder
-	// hierarchy, but don't add it to the playlists hierarchy
+// addTrackToHierarchies adds t to all configured hierarchies and (if
+// configured) the folder hierarchy, but not to the playlists hierarchy
+func (me *Content) addTrackToHierarchies(count *uint32, t *track) (err error) {
 	for i := 0; i < len(me.cfg.Cnt.Hiers); i++ {
 		if err := me.addTrackToHierarchy(count, &me.cfg.Cnt.Hiers[i], me.root.childByIndex(i).(container), t); err != nil {
 			return err
@@ -532,8 +719,17 @@ func (me *Content) delTrack(wg *sync.WaitGroup, count *uint32, ti trackInfo) (er
 	*count++
 	// remove from tracks
 	delete(me.tracks, ti.path())
+	me.paths.del(ti.path())
 	// remove from objects
 	delete(me.objects, t.id())
+	// remove from albums and hierarchies
+	me.detachTrack(count, t, true)
+	return
+}
+
+// detachTrack removes the track t from its album and from the hierarchies. If
+// playlists is false, t is kept in the playlists
+func (me *Content) detachTrack(count *uint32, t *track, playlists bool) {
 	// remove from albums
 	a, exists := me.albums[t.albumKey()]
 	if exists {
@@ -547,6 +743,14 @@ func (me *Content) delTrack(wg *sync.WaitGroup, count *uint32, ti trackInfo) (er
 	}
 	// remove from hierarchies
 	for _, tRef := range t.refs {
+		// the parent of a reference is the container that's embedded in the
+		// playlist. Thus, the playlist must be retrieved from the objects
+		if _, isPlaylist := me.objects[tRef.parent().id()].(*playlist); isPlaylist && !playlists {
+			continue
+		}
+		if !playlists {
+			delete(t.refs, tRef.id())
+		}
 		var obj object = tRef
 		for parent := tRef.parent(); parent.parent() != nil; parent = parent.parent() {
 			delete(me.objects, obj.id())
@@ -587,6 +791,25 @@ func strOfLength(s string, n int) string {
 	return s[:n]
 }
 
+// withPlaceholder returns v if it's not empty. Otherwise, the placeholder that
+// is configured for hierarchy levels of type lvl is returned
+func (me *Content) withPlaceholder(lvl config.LevelType, v string) string {
+	if len(v) > 0 {
+		return v
+	}
+	return me.cfg.Cnt.Placeholder(lvl)
+}
+
+// withPlaceholders replaces empty values of vs by the placeholder that is
+// configured for hierarchy levels of type lvl
+func (me *Content) withPlaceholders(lvl config.LevelType, vs []string) []string {
+	result := make([]string, len(vs))
+	for i, v := range vs {
+		result[i] = me.withPlaceholder(lvl, v)
+	}
+	return result
+}
+
 // AlbumsSpreadAcrossMultipleDirs determines albums whose tracks are spread
 // across more than one directory. The result is printed to w
 func (me *Content) AlbumsSpreadAcrossMultipleDirs(w io.Writer) {
@@ -610,7 +833,7 @@ func (me *Content) AlbumsSpreadAcrossMultipleDirs(w io.Writer) {
 		}
 
 		if len(dirs) > 1 {
-			fmt.Fprintf(w, "%-18s %-30s %-30s\n", strOfLength(t0.tags.genres[0], 18), strOfLength(t0.tags.albumArtists[0], 30), strOfLength(t0.tags.album, 30))
+			fmt.Fprintf(w, "%-18s %-30s %-30s\n", strOfLength(me.withPlaceholder(config.LvlGenre, t0.tags.genres[0]), 18), strOfLength(me.withPlaceholder(config.LvlAlbumArtist, t0.tags.albumArtists[0]), 30), strOfLength(t0.tags.album, 30))
 			for dir := range dirs {
 				fmt.Fprintf(w, "\t%s\n", dir)
 			}
@@ -638,7 +861,7 @@ func (me *Content) AlbumsWithInconsistentTrackNumbers(w io.Writer) {
 		for i := 0; i < a.numChildren() && consistent; i++ {
 			t := a.childByIndex(i).(*track)
 			if _, exists := nums[t.tags.trackNo]; exists {
-				fmt.Fprintf(w, "%-18s %-30s %-30s\n", strOfLength(t.tags.genres[0], 18), strOfLength(t.tags.albumArtists[0], 30), strOfLength(t.tags.album, 30))
+				fmt.Fprintf(w, "%-18s %-30s %-30s\n", strOfLength(me.withPlaceholder(config.LvlGenre, t.tags.genres[0]), 18), strOfLength(me.withPlaceholder(config.LvlAlbumArtist, t.tags.albumArtists[0]), 30), strOfLength(t.tags.album, 30))
 				consistent = false
 			} else {
 				nums[t.tags.trackNo] = struct{}{}
@@ -651,7 +874,7 @@ func (me *Content) AlbumsWithInconsistentTrackNumbers(w io.Writer) {
 
 		for i := 0; i < len(nums) && consistent; i++ {
 			if _, exists := nums[i+1]; !exists {
-				fmt.Fprintf(w, "%-18s %-30s %-30s\n", strOfLength(t.tags.genres[0], 18), strOfLength(t.tags.albumArtists[0], 30), strOfLength(t.tags.album, 30))
+				fmt.Fprintf(w, "%-18s %-30s %-30s\n", strOfLength(me.withPlaceholder(config.LvlGenre, t.tags.genres[0]), 18), strOfLength(me.withPlaceholder(config.LvlAlbumArtist, t.tags.albumArtists[0]), 30), strOfLength(t.tags.album, 30))
 				consistent = false
 			}
 		}
@@ -675,13 +898,32 @@ func (me *Content) AlbumsWithMultipleCovers(w io.Writer) {
 				continue
 			}
 			if t.picID.valid != picID.valid || t.picID.id != picID.id {
-				fmt.Fprintf(w, "%-18s %-30s %-30s\n", strOfLength(t.tags.genres[0], 18), strOfLength(t.tags.albumArtists[0], 30), strOfLength(t.tags.album, 30))
+				fmt.Fprintf(w, "%-18s %-30s %-30s\n", strOfLength(me.withPlaceholder(config.LvlGenre, t.tags.genres[0]), 18), strOfLength(me.withPlaceholder(config.LvlAlbumArtist, t.tags.albumArtists[0]), 30), strOfLength(t.tags.album, 30))
 				break L
 			}
 		}
 	}
 }
 
+// AlbumsWithoutLoudness determines albums with tracks that have no loudness
+// data (i.e. no track or album gain) - neither from their tags nor from a
+// loudness measurement. The result is printed to w
+func (me *Content) AlbumsWithoutLoudness(w io.Writer) {
+	fmt.Fprint(w, "Albums without loudness data:\n\n")
+	fmt.Fprintf(w, "%-18s %-30s %-30s\n", "Genre", "AlbumArtist", "Album")
+	fmt.Fprintf(w, "%s\n", space)
+
+	for _, a := range me.albums {
+		for i := 0; i < a.numChildren(); i++ {
+			t := a.childByIndex(i).(*track)
+			if l := t.loudness(); !l.track.valid || !l.album.valid {
+				fmt.Fprintf(w, "%-18s %-30s %-30s\n", strOfLength(me.withPlaceholder(config.LvlGenre, t.tags.genres[0]), 18), strOfLength(me.withPlaceholder(config.LvlAlbumArtist, t.tags.albumArtists[0]), 30), strOfLength(t.tags.album, 30))
+				break
+			}
+		}
+	}
+}
+
 // InconsistentAlbums checks if albums with the same title from the same album
 // artists have the same year and compilation flag assigned. If that's not the
 // case, that's an indicator for an inconsistency and the album data is
@@ -714,7 +956,7 @@ func (me *Content) InconsistentAlbums(w io.Writer) {
 		if album.year != t.tags.year || album.compilation != t.tags.compilation {
 			_, exists := incons[key]
 			if !exists {
-				fmt.Fprintf(w, "Genre: '%v', albumArtist: '%v', Album: '%s',  track: '%s' - differences: ", t.tags.genres, t.tags.albumArtists, t.tags.album, t.name())
+				fmt.Fprintf(w, "Genre: '%v', albumArtist: '%v', Album: '%s',  track: '%s' - differences: ", me.withPlaceholders(config.LvlGenre, t.tags.genres), me.withPlaceholders(config.LvlAlbumArtist, t.tags.albumArtists), t.tags.album, t.name())
 				if album.year != t.tags.year {
 					fmt.Fprint(w, "years ")
 				}
@@ -729,13 +971,33 @@ func (me *Content) InconsistentAlbums(w io.Writer) {
 	}
 }
 
+// TracksWithCharsetIssues determines tracks where tag values have been repaired
+// since they were decoded with the wrong charset, or where tag values are
+// still suspicious. The result is printed to w
+func (me *Content) TracksWithCharsetIssues(w io.Writer) {
+	fmt.Fprint(w, "Tracks with charset issues:\n")
+	for _, t := range me.tracks {
+		if !t.tags.charsetRepaired && !t.tags.charsetSuspicious {
+			continue
+		}
+		var issues string
+		if t.tags.charsetRepaired {
+			issues = "repaired "
+		}
+		if t.tags.charsetSuspicious {
+			issues += "suspicious "
+		}
+		fmt.Fprintf(w, "Path: '%s', album: '%s',  track: '%s' - %s\n", t.path, t.tags.album, t.name(), issues)
+	}
+}
+
 // TracksWithoutAlbum determines tracks that do not have a album tag assigned.
 // The result is printed to w
 func (me *Content) TracksWithoutAlbum(w io.Writer) {
 	fmt.Fprint(w, "Tracks without album:\n")
 	for _, t := range me.tracks {
 		if len(t.tags.album) == 0 {
-			fmt.Fprintf(w, "Genre: '%v', albumArtists: '%v', album: '%s',  track: '%s'\n", t.tags.genres, t.tags.albumArtists, t.tags.album, t.name())
+			fmt.Fprintf(w, "Genre: '%v', albumArtists: '%v', album: '%s',  track: '%s'\n", me.withPlaceholders(config.LvlGenre, t.tags.genres), me.withPlaceholders(config.LvlAlbumArtist, t.tags.albumArtists), t.tags.album, t.name())
 		}
 	}
 }
@@ -746,7 +1008,7 @@ func (me *Content) TracksWithoutCover(w io.Writer) {
 	fmt.Fprint(w, "Tracks without cover pictures:\n")
 	for _, t := range me.tracks {
 		if !t.picID.valid {
-			fmt.Fprintf(w, "Genre: '%v', albumArtists: '%v', album: '%s',  track: '%s'\n", t.tags.genres, t.tags.albumArtists, t.tags.album, t.name())
+			fmt.Fprintf(w, "Genre: '%v', albumArtists: '%v', album: '%s',  track: '%s'\n", me.withPlaceholders(config.LvlGenre, t.tags.genres), me.withPlaceholders(config.LvlAlbumArtist, t.tags.albumArtists), t.tags.album, t.name())
 		}
 	}
 }
@@ -0,0 +1,89 @@
+package content
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+
+	"github.com/dhowden/tag"
+)
+
+// date represents a release date. Depending on the precision of the date, day
+// or month and day can be 0
+type date struct {
+	year, month, day int
+}
+
+// reDate matches dates, such as "2001", "2001-05", "2001-05-17",
+// "2001-05-17T07:00:00Z" or "20010517"
+var reDate = regexp.MustCompile(`^\s*(\d{4})(?:[-/.]?(\d{2})(?:[-/.]?(\d{2}))?)?`)
+
+// parseDate parses s into a date. If s is not a valid date, a zero date is
+// returned
+func parseDate(s string) (d date) {
+	m := reDate.FindStringSubmatch(s)
+	if m == nil {
+		return
+	}
+	d.year, _ = strconv.Atoi(m[1])
+	if len(m[2]) > 0 {
+		d.month, _ = strconv.Atoi(m[2])
+	}
+	if len(m[3]) > 0 {
+		d.day, _ = strconv.Atoi(m[3])
+	}
+	if d.month < 1 || d.month > 12 {
+		d.month, d.day = 0, 0
+	}
+	if d.day < 1 || d.day > 31 {
+		d.day = 0
+	}
+	return
+}
+
+// readDates reads the release date and the original release date from the
+// tags m. If there's no full release date, the year from m is taken
+func readDates(m tag.Metadata) (released, original date) {
+	released = parseDate(rawTag(m, "TDRC", "TYER", "TYE", "date", "\xa9day"))
+	// for ID3v2.3 and ID3v2.2, day and month are stored separately (as DDMM)
+	if released.year > 0 && released.month == 0 {
+		if ddmm := rawTag(m, "TDAT", "TDA"); len(ddmm) == 4 {
+			released = parseDate(fmt.Sprintf("%04d-%s-%s", released.year, ddmm[2:], ddmm[:2]))
+		}
+	}
+	if released.year == 0 {
+		released = date{year: m.Year()}
+	}
+
+	original = parseDate(rawTag(m, "TDOR", "TORY", "TOR", "originaldate", "originalyear", "original year", "originalreleasedate"))
+
+	return
+}
+
+// String returns the date in ISO 8601 format with its actual precision (i.e.
+// "2001", "2001-05" or "2001-05-17")
+func (me date) String() string {
+	switch {
+	case me.year == 0:
+		return ""
+	case me.month == 0:
+		return fmt.Sprintf("%04d", me.year)
+	case me.day == 0:
+		return fmt.Sprintf("%04d-%02d", me.year, me.month)
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", me.year, me.month, me.day)
+}
+
+// sortString returns the date as string that can be used for sorting
+func (me date) sortString() string {
+	return fmt.Sprintf("%04d-%02d-%02d", me.year, me.month, me.day)
+}
+
+// originalYear returns the year of the original release date. If that's not
+// known, year (i.e. the year of the release) is returned
+func originalYear(original date, year int) int {
+	if original.year > 0 {
+		return original.year
+	}
+	return year
+}
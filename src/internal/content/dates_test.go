@@ -0,0 +1,128 @@
+package content
+
+import "testing"
+
+// rawMetadata is a tag.Metadata with the raw tags raw and the year year
+type rawMetadata struct {
+	noMetadata
+	raw  map[string]interface{}
+	year int
+}
+
+func (me rawMetadata) Raw() map[string]interface{} { return me.raw }
+func (me rawMetadata) Year() int                   { return me.year }
+
+func TestParseDate(t *testing.T) {
+	tests := []struct {
+		s    string
+		want date
+	}{
+		{"", date{}},
+		{"unknown", date{}},
+		{"198", date{}},
+		{"2001", date{2001, 0, 0}},
+		{" 2001", date{2001, 0, 0}},
+		{"2001-05", date{2001, 5, 0}},
+		{"2001-05-17", date{2001, 5, 17}},
+		{"2001/05/17", date{2001, 5, 17}},
+		{"2001.05.17", date{2001, 5, 17}},
+		{"20010517", date{2001, 5, 17}},
+		{"2001-05-17T07:00:00Z", date{2001, 5, 17}},
+		// single digits are not accepted for month and day
+		{"2001-5-17", date{2001, 0, 0}},
+		{"2001-05-7", date{2001, 5, 0}},
+		// invalid months and days are dropped
+		{"2001-13-01", date{2001, 0, 0}},
+		{"2001-00-17", date{2001, 0, 0}},
+		{"2001-05-32", date{2001, 5, 0}},
+		{"2001-05-00", date{2001, 5, 0}},
+	}
+	for _, tt := range tests {
+		if got := parseDate(tt.s); got != tt.want {
+			t.Errorf("parseDate(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestDateString(t *testing.T) {
+	tests := []struct {
+		d    date
+		want string
+		sort string
+	}{
+		{date{}, "", "0000-00-00"},
+		{date{2001, 0, 0}, "2001", "2001-00-00"},
+		{date{2001, 5, 0}, "2001-05", "2001-05-00"},
+		{date{2001, 5, 17}, "2001-05-17", "2001-05-17"},
+	}
+	for _, tt := range tests {
+		if got := tt.d.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.d, got, tt.want)
+		}
+		if got := tt.d.sortString(); got != tt.sort {
+			t.Errorf("%+v.sortString() = %q, want %q", tt.d, got, tt.sort)
+		}
+	}
+}
+
+func TestReadDates(t *testing.T) {
+	tests := []struct {
+		name     string
+		m        rawMetadata
+		released date
+		original date
+	}{
+		{
+			name: "no dates",
+			m:    rawMetadata{},
+		},
+		{
+			name:     "year only",
+			m:        rawMetadata{year: 1999},
+			released: date{1999, 0, 0},
+		},
+		{
+			name:     "ID3v2.4",
+			m:        rawMetadata{raw: map[string]interface{}{"TDRC": "2001-05-17", "TDOR": "1975"}, year: 2001},
+			released: date{2001, 5, 17},
+			original: date{1975, 0, 0},
+		},
+		{
+			name:     "ID3v2.3 with TDAT",
+			m:        rawMetadata{raw: map[string]interface{}{"TYER": "2001", "TDAT": "1705", "TORY": "1975"}, year: 2001},
+			released: date{2001, 5, 17},
+			original: date{1975, 0, 0},
+		},
+		{
+			name:     "ID3v2.3 with invalid TDAT",
+			m:        rawMetadata{raw: map[string]interface{}{"TYER": "2001", "TDAT": "17"}, year: 2001},
+			released: date{2001, 0, 0},
+		},
+		{
+			name:     "Vorbis comments",
+			m:        rawMetadata{raw: map[string]interface{}{"date": "2001-05", "originaldate": "1975-03-01"}, year: 2001},
+			released: date{2001, 5, 0},
+			original: date{1975, 3, 1},
+		},
+		{
+			name:     "unparsable date",
+			m:        rawMetadata{raw: map[string]interface{}{"date": "May 2001"}, year: 2001},
+			released: date{2001, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		released, original := readDates(tt.m)
+		if released != tt.released || original != tt.original {
+			t.Errorf("%s: readDates() = %+v, %+v, want %+v, %+v", tt.name, released, original, tt.released, tt.original)
+		}
+	}
+}
+
+func TestOriginalYear(t *testing.T) {
+	if got := originalYear(date{1975, 3, 1}, 2001); got != 1975 {
+		t.Errorf("originalYear() = %d, want 1975", got)
+	}
+	if got := originalYear(date{}, 2001); got != 2001 {
+		t.Errorf("originalYear() = %d, want 2001", got)
+	}
+}
@@ -0,0 +1,253 @@
+package content
+
+// this file contains the logic for the rules that are defined per directory of
+// the music directories: .museignore files exclude files and directories (with
+// the semantics of .gitignore files). In addition to these files, the exclude
+// patterns from the configuration are applied to all music directories.
+// .muserv.json files force settings for the tracks of a directory and its sub
+// directories (compilation flag, hierarchies and separator). If directories
+// are nested, the settings of the inner directories take precedence
+
+import (
+	"encoding/json"
+	"os"
+	p "path"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"gitlab.com/mipimipi/muserv/src/internal/config"
+)
+
+// names of the files that contain directory rules
+const (
+	ignoreFile      = ".museignore"
+	dirSettingsFile = ".muserv.json"
+)
+
+// dirSettings contains the settings of a .muserv.json file. Only settings that
+// are set are applied
+type dirSettings struct {
+	Compilation *bool    `json:"compilation"`
+	Hierarchies []string `json:"hierarchies"` // names of the hierarchies that the tracks are part of
+	Separator   *string  `json:"separator"`   // replaces the global separator
+}
+
+// merge sets the settings that are set in s
+func (me *dirSettings) merge(s dirSettings) {
+	if s.Compilation != nil {
+		me.Compilation = s.Compilation
+	}
+	if s.Hierarchies != nil {
+		me.Hierarchies = s.Hierarchies
+	}
+	if s.Separator != nil {
+		me.Separator = s.Separator
+	}
+}
+
+// ruleFile contains the content of a .museignore or .muserv.json file
+type ruleFile struct {
+	lastChange int64                  // UNIX time of last change of the file
+	patterns   []config.IgnorePattern // patterns of a .museignore file
+	settings   dirSettings            // settings of a .muserv.json file
+}
+
+// dirRules provides the rules of the music directories. The files that contain
+// the rules are cached and only read again if they have been changed. Since
+// the rules are evaluated during concurrent directory traversals, all access is
+// synchronized
+type dirRules struct {
+	mu    sync.Mutex
+	cfg   *config.Cfg
+	files map[string]*ruleFile // cache of .museignore and .muserv.json files
+}
+
+// newDirRules creates a new dirRules instance from the configuration
+func newDirRules(cfg *config.Cfg) *dirRules {
+	return &dirRules{
+		cfg:   cfg,
+		files: make(map[string]*ruleFile),
+	}
+}
+
+// file returns the rule file path. nil is returned if it doesn't exist
+func (me *dirRules) file(path string) *ruleFile {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+	lastChange := int64(info.Sys().(*syscall.Stat_t).Ctim.Sec)
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	rf, exists := me.files[path]
+	if !exists || rf.lastChange != lastChange {
+		rf = me.readFile(path, lastChange)
+		me.files[path] = rf
+	}
+	return rf
+}
+
+// readFile reads the rule file path. Errors are logged. Invalid lines of
+// .museignore files are skipped
+func (me *dirRules) readFile(path string, lastChange int64) *ruleFile {
+	rf := ruleFile{lastChange: lastChange}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Error(errors.Wrapf(err, "cannot read '%s'", path))
+		return &rf
+	}
+
+	if p.Base(path) == ignoreFile {
+		for _, line := range strings.Split(string(b), "\n") {
+			pat, ok, err := config.ParseIgnorePattern(line)
+			if err != nil {
+				log.Error(errors.Wrapf(err, "'%s'", path))
+				continue
+			}
+			if ok {
+				rf.patterns = append(rf.patterns, pat)
+			}
+		}
+		return &rf
+	}
+
+	if err = json.Unmarshal(b, &rf.settings); err != nil {
+		log.Error(errors.Wrapf(err, "cannot read '%s'", path))
+		return &rf
+	}
+	for _, name := range rf.settings.Hierarchies {
+		if !me.isHierarchy(name) {
+			log.Errorf("'%s' contains the unknown hierarchy '%s'", path, name)
+		}
+	}
+	return &rf
+}
+
+// isHierarchy returns true if a hierarchy with the given name is configured
+func (me *dirRules) isHierarchy(name string) bool {
+	for _, h := range me.cfg.Cnt.Hiers {
+		if h.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// isRuleFile returns true if path is a .museignore or a .muserv.json file
+func isRuleFile(path string) bool {
+	name := p.Base(path)
+	return name == ignoreFile || name == dirSettingsFile
+}
+
+// dirsBelow returns the directories from root down to dir (both included). If
+// dir is not a sub directory of root, only dir is returned
+func dirsBelow(root, dir string) []string {
+	dirs := []string{dir}
+	for dir != root && dir != "/" && dir != "." {
+		dir = p.Dir(dir)
+		dirs = append(dirs, dir)
+	}
+	if dir != root {
+		return dirs[:1]
+	}
+	for i, j := 0, len(dirs)-1; i < j; i, j = i+1, j-1 {
+		dirs[i], dirs[j] = dirs[j], dirs[i]
+	}
+	return dirs
+}
+
+// isExcluded returns true if the file or directory path is excluded by the
+// exclude patterns or by .museignore files. Only path itself is checked. The
+// directories that contain path are not checked, i.e. it's assumed that they
+// are not excluded
+func (me *dirRules) isExcluded(path string, isDir bool) bool {
+	if me == nil {
+		return false
+	}
+	root := me.cfg.Cnt.MusicDir(path)
+	if len(root) == 0 || path == root {
+		return false
+	}
+
+	layers := []ignoreLayer{{root, me.cfg.Cnt.Excludes()}}
+	for _, dir := range dirsBelow(root, p.Dir(path)) {
+		layers = me.addIgnoreLayer(layers, dir)
+	}
+	return excludedBy(layers, path, isDir)
+}
+
+// isIgnored returns true if the file or directory path or one of the
+// directories that contain it is excluded. The directories are checked from
+// the music directory downwards, and the .museignore files are read on the way
+func (me *dirRules) isIgnored(path string, isDir bool) bool {
+	if me == nil {
+		return false
+	}
+	root := me.cfg.Cnt.MusicDir(path)
+	if len(root) == 0 || path == root {
+		return false
+	}
+
+	layers := []ignoreLayer{{root, me.cfg.Cnt.Excludes()}}
+	for _, dir := range dirsBelow(root, p.Dir(path)) {
+		if dir != root && excludedBy(layers, dir, true) {
+			return true
+		}
+		layers = me.addIgnoreLayer(layers, dir)
+	}
+	return excludedBy(layers, path, isDir)
+}
+
+// ignoreLayer contains the ignore patterns that are defined for a directory
+type ignoreLayer struct {
+	dir      string
+	patterns []config.IgnorePattern
+}
+
+// addIgnoreLayer adds the patterns of the .museignore file of directory dir to
+// layers (if the file exists)
+func (me *dirRules) addIgnoreLayer(layers []ignoreLayer, dir string) []ignoreLayer {
+	if rf := me.file(p.Join(dir, ignoreFile)); rf != nil {
+		layers = append(layers, ignoreLayer{dir, rf.patterns})
+	}
+	return layers
+}
+
+// excludedBy returns true if the file or directory path is excluded by the
+// patterns of layers. The last pattern that matches determines the result
+func excludedBy(layers []ignoreLayer, path string, isDir bool) (excluded bool) {
+	for _, l := range layers {
+		rel := strings.TrimPrefix(path, l.dir+"/")
+		for i := range l.patterns {
+			if l.patterns[i].Match(rel, isDir) {
+				excluded = !l.patterns[i].Negates()
+			}
+		}
+	}
+	return
+}
+
+// settings returns the settings for the track file path that result from the
+// .muserv.json files and the time of the last change of these files (0 if
+// there are no such files)
+func (me *dirRules) settings(path string) (s dirSettings, lastChange int64) {
+	if me == nil {
+		return
+	}
+	root := me.cfg.Cnt.MusicDir(path)
+	if len(root) == 0 {
+		return
+	}
+	for _, dir := range dirsBelow(root, p.Dir(path)) {
+		if rf := me.file(p.Join(dir, dirSettingsFile)); rf != nil {
+			s.merge(rf.settings)
+			lastChange = max(lastChange, rf.lastChange)
+		}
+	}
+	return
+}
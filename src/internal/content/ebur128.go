@@ -0,0 +1,348 @@
+package content
+
+// this file contains the measurement of the integrated loudness of track files
+// according to EBU R128 (ITU-R BS.1770). The audio data is decoded natively for
+// WAV and via package flac for FLAC
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	p "path"
+
+	"github.com/mewkiz/flac"
+)
+
+// gating thresholds of EBU R128
+const (
+	absoluteGate = -70.0 // in LUFS
+	relativeGate = -10.0 // in LU
+)
+
+// errSilence is returned if the loudness of a track cannot be measured since
+// it's (almost) silent
+var errSilence = errors.New("track is silent")
+
+// measureLoudness measures the integrated loudness and the sample peak of the
+// track file path. The file is read with the rate that limiter allows
+func measureLoudness(path string, limiter *rateLimiter) (m measurement, err error) {
+	var mtr *meter
+	switch p.Ext(path) {
+	case ".flac":
+		mtr, err = meterFLAC(path, limiter)
+	case ".wav":
+		mtr, err = meterWAV(path, limiter)
+	default:
+		err = fmt.Errorf("loudness of '%s' cannot be measured", path)
+	}
+	if err != nil {
+		return
+	}
+
+	if m.Loudness, err = mtr.integratedLoudness(); err != nil {
+		return
+	}
+	m.Peak = mtr.peak
+	m.Duration = float64(mtr.samples) / mtr.rate
+	return
+}
+
+// biquad is a second order IIR filter
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	x1, x2, y1, y2     float64
+}
+
+// process filters the sample x
+func (me *biquad) process(x float64) float64 {
+	y := me.b0*x + me.b1*me.x1 + me.b2*me.x2 - me.a1*me.y1 - me.a2*me.y2
+	me.x2, me.x1 = me.x1, x
+	me.y2, me.y1 = me.y1, y
+	return y
+}
+
+// kWeighting returns the two filters of the K-weighting (a high shelf filter
+// and a high pass filter) for the sample rate rate. The coefficients are
+// derived from the analog prototypes, so that any sample rate is supported
+func kWeighting(rate float64) (shelf, highPass biquad) {
+	f0, g, q := 1681.974450955533, 3.999843853973347, 0.7071752369554196
+	k := math.Tan(math.Pi * f0 / rate)
+	vh := math.Pow(10, g/20)
+	vb := math.Pow(vh, 0.4996667741545416)
+	a0 := 1 + k/q + k*k
+	shelf = biquad{
+		b0: (vh + vb*k/q + k*k) / a0,
+		b1: 2 * (k*k - vh) / a0,
+		b2: (vh - vb*k/q + k*k) / a0,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+
+	f0, q = 38.13547087602444, 0.5003270373238773
+	k = math.Tan(math.Pi * f0 / rate)
+	a0 = 1 + k/q + k*k
+	highPass = biquad{
+		b0: 1,
+		b1: -2,
+		b2: 1,
+		a1: 2 * (k*k - 1) / a0,
+		a2: (1 - k/q + k*k) / a0,
+	}
+	return
+}
+
+// meter measures the loudness of audio data. The mean squares of the
+// K-weighted samples are collected in blocks of 100 ms. The gating blocks of
+// 400 ms (with an overlap of 75%) are assembled from them
+type meter struct {
+	rate     float64
+	filters  [][2]biquad // K-weighting filters per channel
+	weights  []float64   // weights per channel
+	sum      float64     // weighted sum of squares of the current 100 ms block
+	n        int         // number of samples in the current 100 ms block
+	size     int         // number of samples of a 100 ms block
+	blocks   []float64   // mean squares of the 100 ms blocks
+	samples  int64       // total number of samples (per channel)
+	peak     float64     // sample peak
+	channels int
+}
+
+// newMeter creates a meter for audio data with the given sample rate and
+// number of channels
+func newMeter(rate, channels int) (*meter, error) {
+	if rate <= 0 || channels <= 0 {
+		return nil, fmt.Errorf("invalid audio format (sample rate %d, %d channels)", rate, channels)
+	}
+	m := meter{
+		rate:     float64(rate),
+		filters:  make([][2]biquad, channels),
+		weights:  make([]float64, channels),
+		size:     rate / 10,
+		channels: channels,
+	}
+	for i := 0; i < channels; i++ {
+		shelf, highPass := kWeighting(m.rate)
+		m.filters[i] = [2]biquad{shelf, highPass}
+		// channel order L, R, C, LFE, Ls, Rs: the LFE channel is ignored,
+		// surround channels are weighted higher
+		switch {
+		case channels >= 6 && i == 3:
+			m.weights[i] = 0
+		case channels >= 5 && i >= channels-2:
+			m.weights[i] = 1.41
+		default:
+			m.weights[i] = 1
+		}
+	}
+	return &m, nil
+}
+
+// add adds one sample per channel. The sample values are between -1 and 1
+func (me *meter) add(frame []float64) {
+	for i, x := range frame {
+		me.peak = math.Max(me.peak, math.Abs(x))
+		y := me.filters[i][1].process(me.filters[i][0].process(x))
+		me.sum += me.weights[i] * y * y
+	}
+	me.samples++
+	if me.n++; me.n == me.size {
+		me.blocks = append(me.blocks, me.sum/float64(me.size))
+		me.sum, me.n = 0, 0
+	}
+}
+
+// integratedLoudness returns the gated integrated loudness in LUFS
+func (me *meter) integratedLoudness() (float64, error) {
+	// gating blocks of 400 ms
+	var gated []float64
+	for i := 3; i < len(me.blocks); i++ {
+		z := (me.blocks[i-3] + me.blocks[i-2] + me.blocks[i-1] + me.blocks[i]) / 4
+		if loudnessOf(z) > absoluteGate {
+			gated = append(gated, z)
+		}
+	}
+	if len(gated) == 0 {
+		return 0, errSilence
+	}
+
+	threshold := loudnessOf(mean(gated)) + relativeGate
+	var relGated []float64
+	for _, z := range gated {
+		if loudnessOf(z) > threshold {
+			relGated = append(relGated, z)
+		}
+	}
+	return loudnessOf(mean(relGated)), nil
+}
+
+// loudnessOf returns the loudness (in LUFS) for the mean square z
+func loudnessOf(z float64) float64 {
+	return -0.691 + 10*math.Log10(z)
+}
+
+// mean returns the arithmetic mean of zs
+func mean(zs []float64) (m float64) {
+	for _, z := range zs {
+		m += z
+	}
+	return m / float64(len(zs))
+}
+
+// meterFLAC decodes the FLAC file path and measures its loudness
+func meterFLAC(path string, limiter *rateLimiter) (*meter, error) {
+	f, err := openLimited(path, limiter)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stream, err := flac.New(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+
+	mtr, err := newMeter(int(stream.Info.SampleRate), int(stream.Info.NChannels))
+	if err != nil {
+		return nil, err
+	}
+	scale := float64(int64(1) << (stream.Info.BitsPerSample - 1))
+	frame := make([]float64, mtr.channels)
+
+	for {
+		f, err := stream.ParseNext()
+		if err == io.EOF {
+			return mtr, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(f.Subframes) != mtr.channels {
+			return nil, fmt.Errorf("inconsistent number of channels")
+		}
+		for i := 0; i < int(f.BlockSize); i++ {
+			for ch := range frame {
+				frame[ch] = float64(f.Subframes[ch].Samples[i]) / scale
+			}
+			mtr.add(frame)
+		}
+	}
+}
+
+// WAV sample formats
+const (
+	wavPCM        = 1
+	wavFloat      = 3
+	wavExtensible = 0xfffe
+)
+
+// meterWAV decodes the WAV file path and measures its loudness. Integer PCM
+// (8, 16, 24 and 32 bits) and floating point data (32 and 64 bits) are
+// supported
+func meterWAV(path string, limiter *rateLimiter) (*meter, error) {
+	f, err := openLimited(path, limiter)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WAVE" {
+		return nil, fmt.Errorf("no WAV file")
+	}
+
+	var (
+		format, channels, bits int
+		rate                   int
+	)
+	chunk := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return nil, err
+		}
+		id, size := string(chunk[:4]), int64(binary.LittleEndian.Uint32(chunk[4:]))
+
+		switch id {
+		case "fmt ":
+			if size < 16 || size > maxRawTagSize {
+				return nil, fmt.Errorf("invalid fmt chunk")
+			}
+			b := make([]byte, size+size%2)
+			if _, err := io.ReadFull(r, b); err != nil {
+				return nil, err
+			}
+			format = int(binary.LittleEndian.Uint16(b[0:2]))
+			channels = int(binary.LittleEndian.Uint16(b[2:4]))
+			rate = int(binary.LittleEndian.Uint32(b[4:8]))
+			bits = int(binary.LittleEndian.Uint16(b[14:16]))
+			// for the extensible format, the actual format is contained in
+			// the first two bytes of the sub format GUID
+			if format == wavExtensible && size >= 26 {
+				format = int(binary.LittleEndian.Uint16(b[24:26]))
+			}
+
+		case "data":
+			if channels == 0 {
+				return nil, fmt.Errorf("data chunk before fmt chunk")
+			}
+			sample, err := wavSampleDecoder(format, bits)
+			if err != nil {
+				return nil, err
+			}
+			mtr, err := newMeter(rate, channels)
+			if err != nil {
+				return nil, err
+			}
+			width := bits / 8
+			b := make([]byte, width*channels)
+			frame := make([]float64, channels)
+			for n := size / int64(len(b)); n > 0; n-- {
+				if _, err := io.ReadFull(r, b); err != nil {
+					// accept truncated files
+					if err == io.ErrUnexpectedEOF || err == io.EOF {
+						break
+					}
+					return nil, err
+				}
+				for ch := range frame {
+					frame[ch] = sample(b[ch*width : (ch+1)*width])
+				}
+				mtr.add(frame)
+			}
+			return mtr, nil
+
+		default:
+			if _, err := r.Discard(int(size + size%2)); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// wavSampleDecoder returns a function that converts a WAV sample with the given
+// format and number of bits into a value between -1 and 1
+func wavSampleDecoder(format, bits int) (func([]byte) float64, error) {
+	switch {
+	case format == wavPCM && bits == 8:
+		// 8 bit samples are unsigned
+		return func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }, nil
+	case format == wavPCM && bits == 16:
+		return func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }, nil
+	case format == wavPCM && bits == 24:
+		return func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}, nil
+	case format == wavPCM && bits == 32:
+		return func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }, nil
+	case format == wavFloat && bits == 32:
+		return func(b []byte) float64 { return float64(math.Float32frombits(binary.LittleEndian.Uint32(b))) }, nil
+	case format == wavFloat && bits == 64:
+		return func(b []byte) float64 { return math.Float64frombits(binary.LittleEndian.Uint64(b)) }, nil
+	}
+	return nil, fmt.Errorf("unsupported WAV format %d with %d bits per sample", format, bits)
+}
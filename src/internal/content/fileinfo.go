@@ -7,10 +7,13 @@ import (
 	"mime"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/dhowden/tag"
 	"github.com/pkg/errors"
+	"gitlab.com/mipimipi/muserv/src/internal/config"
 )
 
 // tags of a music file / track file
@@ -18,15 +21,36 @@ type tags struct {
 	title        string
 	album        string
 	artists      []string
+	artistCredit string   // original artist credit (only set if it has been parsed into multiple artists)
+	featured     []string // featured artists (from artist credit)
 	albumArtists []string
 	composers    []string
 	genres       []string
-	year         int
+	year         int  // year of date
+	date         date // release date
+	originalDate date // original release date
 	trackNo      int
 	tracksTotal  int
 	discNo       int
 	discsTotal   int
+	discSubtitle string
 	compilation  bool
+	compForced   bool    // compilation flag is forced by directory settings or tag overrides
+	compDetected bool    // compilation flag is detected by the number of track artists (see compilations.go)
+	rating       float64 // rating on a scale from 0 (not rated) to 5
+	loudness     loudness
+	hasLyrics    bool     // tags contain unsynchronized lyrics (these are read on demand, see Content.Lyrics)
+	hierarchies  []string // names of the hierarchies the track is part of (nil: all)
+	// classical music
+	work         string
+	movementName string
+	movementNo   int
+	conductors   []string
+	orchestras   []string
+	performers   []string
+	// charset repair status
+	charsetRepaired   bool // at least one value has been repaired
+	charsetSuspicious bool // at least one value is still suspicious
 }
 
 type infoKind int
@@ -101,18 +125,62 @@ func (me playlistInfo) kind() infoKind { return infoPlaylist }
 
 type trackInfo struct {
 	baseInfo
+	meta *trackMeta
 }
 
-// newTrackInfo creates an instance of trackInfo
-func newTrackInfo(path string, lastChange int64) trackInfo {
-	return trackInfo{newBaseInfo(path, lastChange)}
+// trackMeta contains the metadata of a track. It's read at most once, so that
+// it can be read ahead by a worker (see procUpdates)
+type trackMeta struct {
+	once sync.Once
+	tgs  *tags
+	pic  *tag.Picture
+	err  error
+}
+
+// newTrackInfo creates an instance of trackInfo. Changes of sidecar files (such
+// as .lrc files) are treated as changes of the track. Thus, if lastChange is 0,
+// the time of the last change is the latest change of the track file and its
+// sidecar files
+func newTrackInfo(cnt *Content, path string, lastChange int64) trackInfo {
+	ti := trackInfo{newBaseInfo(path, lastChange), new(trackMeta)}
+	if lastChange == 0 {
+		lChg := ti.lChg
+		ti.lChg = func() int64 {
+			if lastChange == 0 {
+				lastChange = max(lChg(), cnt.sidecarsLastChange(path))
+			}
+			return lastChange
+		}
+	}
+	return ti
 }
 
 func (me trackInfo) kind() infoKind { return infoTrack }
 
-// metadata reads the ID3 tags and the picture for a track
-func (me trackInfo) metadata(sep string) (tgs *tags, pic *tag.Picture, err error) {
-	f, err := os.Open(me.path())
+// readAhead reads the metadata of the track, so that it's available when the
+// track is processed. It can be executed concurrently to metadata
+func (me trackInfo) readAhead(cnt *Content) {
+	me.meta.once.Do(func() {
+		me.meta.tgs, me.meta.pic, me.meta.err = me.readMetadata(cnt)
+	})
+}
+
+// metadata returns the tags and the picture of the track. They are read only
+// once, and the picture is only returned by the first call to keep the memory
+// consumption low
+func (me trackInfo) metadata(cnt *Content) (tgs *tags, pic *tag.Picture, err error) {
+	me.readAhead(cnt)
+	tgs, pic, err = me.meta.tgs, me.meta.pic, me.meta.err
+	me.meta.pic = nil
+	return
+}
+
+// readMetadata reads the ID3 tags and the picture for a track. The tag values
+// are normalized according to the tag rules from the configuration
+func (me trackInfo) readMetadata(cnt *Content) (tgs *tags, pic *tag.Picture, err error) {
+	cfg := cnt.cfg
+
+	f, err := openLimited(me.path(), cnt.ioLimit)
 	if err != nil {
 		err = errors.Wrapf(err, "cannot retrieve meta data for '%s'", me.path())
 		return
@@ -121,19 +189,129 @@ func (me trackInfo) metadata(sep string) (tgs *tags, pic *tag.Picture, err error
 
 	m, err := tag.ReadFrom(f)
 	if err != nil {
-		err = errors.Wrapf(err, "cannot retrieve meta data for '%s'", me.path())
-		return
+		// files without tags are accepted since tags can be derived from
+		// their paths
+		if err != tag.ErrNoTagsFound {
+			err = errors.Wrapf(err, "cannot retrieve meta data for '%s'", me.path())
+			return
+		}
+		m, err = noMetadata{}, nil
+	}
+
+	// values derived from the path of the track file. fromPath returns the
+	// value for field that shall be used instead of v. ok is false if there
+	// is no such value
+	var (
+		pathValues map[string]string
+		override   bool
+	)
+	if tmpl := cfg.Cnt.PathTemplate(me.path()); tmpl != nil {
+		pathValues, _ = tmpl.Match(me.path())
+		override = tmpl.Overrides()
+	}
+	fromPath := func(field, v string) (string, bool) {
+		pv, exists := pathValues[field]
+		if !exists || len(pv) == 0 || (!override && len(v) > 0) {
+			return v, false
+		}
+		return pv, true
+	}
+	fromPathInt := func(field string, v int) int {
+		s, ok := fromPath(field, "")
+		if !ok || (!override && v > 0) {
+			return v
+		}
+		if i, err := strconv.Atoi(s); err == nil {
+			return i
+		}
+		return v
+	}
+
+	// read tags with multiple values natively
+	raw := readRawTags(f, m)
+
+	// settings of the directory (see dirrules.go)
+	ds, _ := cnt.rules.settings(me.path())
+	sep := cfg.Cnt.Separator
+	if ds.Separator != nil {
+		sep = *ds.Separator
+	}
+
+	// text repairs texts that have been decoded with the wrong charset (if
+	// that's configured) and checks if they are still suspicious
+	var repaired, suspicious bool
+	text := func(s string) string {
+		if cfg.Cnt.CharsetRepair.IsActive() && isLegacyFormat(m.Format()) {
+			var ok bool
+			if s, ok = repairCharset(&cfg.Cnt.CharsetRepair, me.path(), s); ok {
+				repaired = true
+			}
+		}
+		if isSuspiciousText(s) {
+			suspicious = true
+		}
+		return s
+	}
+
+	// split returns the split values of the tag with name tg. value is the
+	// value that package tag provides. names are the names of the tag in the
+	// different tag formats. If multiple values for these names have been read
+	// natively, these values are taken instead of value
+	split := func(tg config.TagName, value string, names ...string) []string {
+		values := raw.get(names...)
+		// if a value from the path shall be used, the values that have been
+		// read natively are ignored
+		if pv, ok := fromPath(string(tg), strings.Join(values, "")+value); ok {
+			value, values = pv, nil
+		}
+		// for a single value, the value provided by package tag is preferred
+		// (if it exists)
+		if len(values) == 0 || (len(values) == 1 && len(value) > 0) {
+			values = []string{value}
+		}
+		seps := &cfg.Cnt.Separators
+		var entries []string
+		for _, v := range values {
+			for _, entry := range splitMultipleEntries(text(v), seps.Of(tg, sep), seps.Escape, seps.Protected) {
+				if len(entry) > 0 {
+					entries = append(entries, entry)
+				}
+			}
+		}
+		if len(entries) == 0 {
+			entries = []string{""}
+		}
+		return entries
+	}
+	// multi returns the split and normalized values of the tag with name tg
+	multi := func(tg config.TagName, value string, names ...string) []string {
+		return normalizeEntries(&cfg.Cnt.TagRules, tg, split(tg, value, names...))
 	}
 
 	// process tags
 	tgs = new(tags)
-	tgs.title = m.Title()
+	tgs.title, _ = fromPath(config.FieldTitle, text(m.Title()))
+	// files without tags (such as most WAV files) get their file name as title
+	// if the path doesn't provide one
+	if _, untagged := m.(noMetadata); untagged && len(tgs.title) == 0 {
+		tgs.title = strings.TrimSuffix(path.Base(me.path()), path.Ext(me.path()))
+	}
 	tgs.trackNo, tgs.tracksTotal = m.Track()
+	tgs.trackNo = fromPathInt(config.FieldTrack, tgs.trackNo)
 	tgs.discNo, tgs.discsTotal = m.Disc()
-	tgs.album = m.Album()
-	tgs.composers = splitMultipleEntries(m.Composer(), sep)
-	tgs.genres = splitMultipleEntries(m.Genre(), sep)
-	tgs.year = m.Year()
+	tgs.discNo = fromPathInt(config.FieldDisc, tgs.discNo)
+	tgs.discSubtitle = text(rawTag(m, "TSST", "discsubtitle"))
+	tgs.album, _ = fromPath(config.FieldAlbum, text(m.Album()))
+	tgs.composers = multi(config.TagComposer, m.Composer(), "composer", "tcom", "\xa9wrt")
+	tgs.genres = multi(config.TagGenre, m.Genre(), "genre", "tcon", "\xa9gen")
+	tgs.date, tgs.originalDate = readDates(m)
+	if year := fromPathInt(config.FieldYear, tgs.date.year); year != tgs.date.year {
+		tgs.date = date{year: year}
+	}
+	tgs.year = tgs.date.year
+	tgs.rating = readRating(m)
+	tgs.loudness = readLoudness(m)
+	tgs.hasLyrics = len(readLyrics(m)) > 0
 	// - compilation
 	i, ok := m.Raw()["compilation"]
 	var s string
@@ -145,19 +323,164 @@ func (me trackInfo) metadata(sep string) (tgs *tags, pic *tag.Picture, err error
 	} else {
 		s = fmt.Sprintf("%v", i)
 	}
-	tgs.compilation = (s == "1")
+	tgs.compilation = (s == "1" || rawTag(m, "TCMP", "TCP") == "1")
+	if ds.Compilation != nil {
+		tgs.compilation, tgs.compForced = *ds.Compilation, true
+	}
 	// - (album) artists
-	tgs.artists = splitMultipleEntries(m.Artist(), sep)
-	tgs.albumArtists = splitMultipleEntries(m.AlbumArtist(), sep)
-	if !tgs.compilation && len(tgs.albumArtists) == 0 {
-		tgs.albumArtists = tgs.artists
+	tgs.artists = split(config.TagArtist, m.Artist(), "artist", "tpe1", "artists", "\xa9art")
+	if cfg.Cnt.ArtistCredits.IsActive() {
+		tgs.artists, tgs.featured, tgs.artistCredit = parseArtistCredits(&cfg.Cnt.ArtistCredits, tgs.artists)
+		tgs.featured = normalizeEntries(&cfg.Cnt.TagRules, config.TagArtist, tgs.featured)
+	}
+	tgs.artists = normalizeEntries(&cfg.Cnt.TagRules, config.TagArtist, tgs.artists)
+	tgs.featured = removeEntries(tgs.featured, tgs.artists)
+	//   note: if the album artist is missing, it's completed later on (see
+	//   Content.completeAlbumArtists) since this requires compilations to be
+	//   detected
+	tgs.albumArtists = multi(config.TagAlbumArtist, m.AlbumArtist(), "albumartist", "album artist", "tpe2", "aart")
+
+	// - classical music
+	single := func(names ...string) string {
+		if values := raw.get(names...); len(values) > 0 {
+			return strings.TrimSpace(text(values[0]))
+		}
+		return text(rawTag(m, names...))
+	}
+	tgs.work = single("work", "txxx:work", "\xa9wrk")
+	tgs.movementName = single("movementname", "mvnm", "txxx:movementname", "\xa9mvn")
+	tgs.movementNo, _ = strconv.Atoi(strings.Split(single("movement", "mvin", "txxx:movement", "\xa9mvi"), "/")[0])
+	tgs.conductors = multi(config.TagConductor, "", "conductor", "tpe3", "txxx:conductor")
+	tgs.orchestras = multi(config.TagOrchestra, "", "orchestra", "ensemble", "txxx:orchestra", "txxx:ensemble")
+	tgs.performers = multi(config.TagPerformer, "", "performer", "txxx:performer")
+	if isEmpty(tgs.performers) {
+		tgs.performers = normalizeEntries(&cfg.Cnt.TagRules, config.TagPerformer, musicianCredits(raw.get("tmcl", "ipls")))
+	}
+
+	// - tag overrides (see overrides.go) take precedence over all other values
+	o, _ := cnt.overrides.get(me.path())
+	overrideMulti := func(tg config.TagName, v string) []string {
+		seps := &cfg.Cnt.Separators
+		return normalizeEntries(&cfg.Cnt.TagRules, tg, splitMultipleEntries(v, seps.Of(tg, sep), seps.Escape, seps.Protected))
+	}
+	if o.Title != nil {
+		tgs.title = *o.Title
+	}
+	if o.AlbumArtist != nil {
+		tgs.albumArtists = overrideMulti(config.TagAlbumArtist, *o.AlbumArtist)
+	}
+	if o.Genre != nil {
+		tgs.genres = overrideMulti(config.TagGenre, *o.Genre)
+	}
+	if o.Year != nil {
+		tgs.date, tgs.year = date{year: *o.Year}, *o.Year
+	}
+	if o.Compilation != nil {
+		tgs.compilation, tgs.compForced = *o.Compilation, true
 	}
 
+	tgs.hierarchies = ds.Hierarchies
+	tgs.charsetRepaired, tgs.charsetSuspicious = repaired, suspicious
+
 	pic = m.Picture()
 
 	return
 }
 
+// musicianCredits converts the musician credits list of ID3v2 (frame TMCL or
+// IPLS), which consists of pairs of roles and names, into a list of performers
+// of the form "name (role)"
+func musicianCredits(credits []string) (performers []string) {
+	for i := 0; i+1 < len(credits); i += 2 {
+		if len(strings.TrimSpace(credits[i+1])) == 0 {
+			continue
+		}
+		if len(strings.TrimSpace(credits[i])) == 0 {
+			performers = append(performers, strings.TrimSpace(credits[i+1]))
+			continue
+		}
+		performers = append(performers, fmt.Sprintf("%s (%s)", strings.TrimSpace(credits[i+1]), strings.TrimSpace(credits[i])))
+	}
+	return
+}
+
+// parseArtistCredits splits the artist credits into main and featured artists.
+// If that results in more than one artist, the credits are returned joined as
+// one string as well
+func parseArtistCredits(ac *config.ArtistCredits, credits []string) (artists, featured []string, credit string) {
+	for _, c := range credits {
+		main, feat := ac.Parse(c)
+		artists = append(artists, main...)
+		featured = append(featured, feat...)
+	}
+	if len(artists)+len(featured) > 1 {
+		credit = strings.Join(credits, ", ")
+	}
+	if len(artists) == 0 {
+		artists = []string{""}
+	}
+	return
+}
+
+// removeEntries removes all entries from entries that are contained in
+// removals as well
+func removeEntries(entries, removals []string) (result []string) {
+	for _, entry := range entries {
+		var found bool
+		for _, r := range removals {
+			if entry == r {
+				found = true
+				break
+			}
+		}
+		if !found && len(entry) > 0 {
+			result = append(result, entry)
+		}
+	}
+	return
+}
+
+// rawTag returns the value of the first raw tag of m whose name is contained in
+// names (the comparison is case-insensitive). For ID3v2, the user defined text
+// frames (TXXX) are considered as well: For them, the description of the frame
+// is compared with names
+func rawTag(m tag.Metadata, names ...string) string {
+	for _, name := range names {
+		for k, v := range m.Raw() {
+			switch val := v.(type) {
+			case string:
+				if strings.EqualFold(k, name) {
+					return strings.TrimSpace(val)
+				}
+			case *tag.Comm:
+				if (strings.HasPrefix(k, "TXXX") || strings.HasPrefix(k, "TXX")) && strings.EqualFold(val.Description, name) {
+					return strings.TrimSpace(val.Text)
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// noMetadata implements tag.Metadata for track files without tags
+type noMetadata struct{}
+
+func (noMetadata) Format() tag.Format          { return "" }
+func (noMetadata) FileType() tag.FileType      { return "" }
+func (noMetadata) Title() string               { return "" }
+func (noMetadata) Album() string               { return "" }
+func (noMetadata) Artist() string              { return "" }
+func (noMetadata) AlbumArtist() string         { return "" }
+func (noMetadata) Composer() string            { return "" }
+func (noMetadata) Year() int                   { return 0 }
+func (noMetadata) Genre() string               { return "" }
+func (noMetadata) Track() (int, int)           { return 0, 0 }
+func (noMetadata) Disc() (int, int)            { return 0, 0 }
+func (noMetadata) Picture() *tag.Picture       { return nil }
+func (noMetadata) Lyrics() string              { return "" }
+func (noMetadata) Comment() string             { return "" }
+func (noMetadata) Raw() map[string]interface{} { return map[string]interface{}{} }
+
 type fileInfos []fileInfo
 
 // implementation of sort interface for trackpaths
@@ -185,11 +508,51 @@ func (me *fileInfos) removeDuplicates() {
 }
 
 // splitMultipleEntries splits a tag that contains multiple entries which are
-// separated by sep into these entries. Each entry is trimmed wrt. left and
-// right spaces
-func splitMultipleEntries(tag, sep string) (meta []string) {
-	for _, s := range strings.Split(tag, sep) {
-		meta = append(meta, strings.TrimSpace(s))
+// separated by one of the separators seps into these entries. Each entry is
+// trimmed wrt. left and right spaces. Values that are contained in protected
+// and separators that are preceded by esc are not split. If tag does not
+// contain any entry, an array that contains the empty string is returned
+func splitMultipleEntries(tag string, seps []string, esc string, protected []string) (meta []string) {
+	// replace protected values and escaped separators by placeholders
+	var masked []string
+	mask := func(s, repl string) {
+		if len(s) == 0 || !strings.Contains(tag, s) {
+			return
+		}
+		tag = strings.ReplaceAll(tag, s, fmt.Sprintf("\x00%d\x00", len(masked)))
+		masked = append(masked, repl)
+	}
+	if len(esc) > 0 {
+		for _, sep := range seps {
+			mask(esc+sep, sep)
+		}
+	}
+	for _, p := range protected {
+		mask(p, p)
+	}
+
+	entries := []string{tag}
+	for _, sep := range seps {
+		if len(sep) == 0 {
+			continue
+		}
+		var split []string
+		for _, entry := range entries {
+			split = append(split, strings.Split(entry, sep)...)
+		}
+		entries = split
+	}
+
+	for _, entry := range entries {
+		for i := 0; i < len(masked); i++ {
+			entry = strings.ReplaceAll(entry, fmt.Sprintf("\x00%d\x00", i), masked[i])
+		}
+		if entry = strings.TrimSpace(entry); len(entry) > 0 {
+			meta = append(meta, entry)
+		}
+	}
+	if len(meta) == 0 {
+		meta = []string{""}
 	}
 	return
 }
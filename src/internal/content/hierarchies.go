@@ -6,6 +6,7 @@ import (
 	"github.com/pkg/errors"
 	"gitlab.com/go-utilities/filepath"
 	"gitlab.com/go-utilities/hash"
+	"gitlab.com/go-utilities/reflect"
 	"gitlab.com/mipimipi/muserv/src/internal/config"
 )
 
@@ -13,9 +14,40 @@ import (
 // hierarchy root ctr. count is increased by the number of object changes that
 // happened during this activity
 func (me *Content) addTrackToHierarchy(count *uint32, hier *config.Hierarchy, ctr container, t *track) (err error) {
+	if isExcludedFromHierarchy(hier, t) {
+		return
+	}
 	return me.addTrackToHierarchyLevel(count, hier, 0, ctr, t)
 }
 
+// isExcludedFromHierarchy returns true if track t must not be added to the
+// hierarchy defined by hier. That's the case if the settings of the directory
+// of t restrict it to other hierarchies, if the rating of t is below the
+// minimum rating of hier, or if hier contains a level that excludes tracks with
+// missing tags, and the tag that corresponds to that level is empty for t.
+// Tracks without album are never added to hierarchies with an album level
+// (they are listed by the check for tracks without album)
+func isExcludedFromHierarchy(hier *config.Hierarchy, t *track) bool {
+	if t.tags.hierarchies != nil && !reflect.Contains(t.tags.hierarchies, hier.Name) {
+		return true
+	}
+	if t.tags.rating < hier.MinRating {
+		return true
+	}
+	for _, lvl := range hier.Levels {
+		if lvl.Type == config.LvlAlbum && len(t.tags.album) == 0 {
+			return true
+		}
+		if !lvl.ExcludeMissing {
+			continue
+		}
+		if isEmpty(t.tagsByLevelType(lvl.Type)) {
+			return true
+		}
+	}
+	return false
+}
+
 // addToTrackHierarchyLevel adds track t to the hierarchy defined by hier as
 // level with the given index as children under ctr.
 // addToHierarchyLevel itself adds the "upper nodes" (i.e. everything - genre,
@@ -38,6 +70,12 @@ func (me *Content) addTrackToHierarchyLevel(count *uint32, hier *config.Hierarch
 			ctrNext = obj.(container)
 		} else {
 			ctrNew := newCtr(me, me.newID(), tags[i])
+			// if the tag is empty, the node gets the configured placeholder
+			// as name. It's sorted as if it had no name, though. I.e. it's
+			// always at the beginning or end of the list
+			if len(tags[i]) == 0 {
+				ctrNew.n = me.cfg.Cnt.Placeholder(hier.Levels[index].Type)
+			}
 			ctrNew.marshalFunc = marshalFuncMux(hier.Levels[index].Type, ctrNew)
 			ctr.addChild(ctrNew)
 			// count creation of new object
@@ -62,10 +100,10 @@ func (me *Content) addTrackToHierarchyLevel(count *uint32, hier *config.Hierarch
 
 // addTrackToSubHierarchy adds track t to the hierarchy defined by hier as level with
 // the given index as children under ctr. This function only takes care of the
-// "lower node" (track and - if required - album). I.e. if required, also the
-// album level is created - after that, the hierarchy is like: ... <- ctr
-// [<- albumRef] <- trackRef. count is increased by the number of object changes
-// that happened during this activity
+// "lower node" (track and - if required - album and disc). I.e. if required,
+// also the album level is created - after that, the hierarchy is like: ... <-
+// ctr [<- albumRef [<- disc]] <- trackRef. count is increased by the number of
+// object changes that happened during this activity
 func (me *Content) addTrackToSubHierarchy(count *uint32, hier *config.Hierarchy, index int, ctr container, t *track) (err error) {
 	// create track reference
 	tRef := t.newTrackRef(hier.Levels[len(hier.Levels)-1].SortFields())
@@ -96,14 +134,22 @@ func (me *Content) addTrackToSubHierarchy(count *uint32, hier *config.Hierarchy,
 			return
 		}
 		aRef = a.newAlbumRef(hier.Levels[index].SortFields())
-		// set comparison functions for sosrting of child objects
-		aRef.setComparison(hier.Levels[index+1].Comparisons())
+		// set comparison functions for sosrting of child objects. Initially,
+		// these are track references - even if the hierarchy contains a
+		// disc level (disc containers are only created for multi-disc albums)
+		aRef.setComparison(hier.Levels[len(hier.Levels)-1].Comparisons())
 		// add album reference to object tree
 		ctr.addChild(aRef)
 		// count change of container
 		*count++
 	}
 
+	// check if disc level must be created
+	if hier.Levels[index+1].Type == config.LvlDisc {
+		me.addTrackRefToDiscLevel(count, hier, index+1, aRef, tRef)
+		return
+	}
+
 	// add track reference to object tree
 	aRef.addChild(tRef)
 	// count change of album reference object
@@ -112,6 +158,73 @@ func (me *Content) addTrackToSubHierarchy(count *uint32, hier *config.Hierarchy,
 	return
 }
 
+// addTrackRefToDiscLevel adds the track reference tRef to the disc level of the
+// hierarchy defined by hier. index is the index of the disc level and aRef the
+// album reference that tRef belongs to. Disc containers are only created if the
+// album consists of more than one disc. Otherwise, tRef is added to aRef
+// directly. count is increased by the number of object changes that happened
+// during this activity
+func (me *Content) addTrackRefToDiscLevel(count *uint32, hier *config.Hierarchy, index int, aRef *albumRef, tRef *trackRef) {
+	// if the album reference has disc containers already, tRef is added to
+	// them even if the album doesn't consist of multiple discs anymore (e.g.
+	// since the tracks of the other discs have been removed), since children
+	// of the album reference must not be a mixture of discs and tracks
+	if !aRef.album.isMultiDisc() && !aRef.hasDiscs() {
+		aRef.addChild(tRef)
+		// count change of album reference object
+		*count++
+		return
+	}
+
+	// the album has multiple discs. If the track references of the album have
+	// been added to the album reference directly so far, they are moved to the
+	// corresponding disc containers
+	if !aRef.hasDiscs() {
+		var objs []object
+		for _, obj := range aRef.children.byID {
+			objs = append(objs, obj)
+		}
+		// the track references are removed before the comparison functions
+		// are switched to the ones of the disc level, since they cannot be
+		// sorted with them
+		for _, obj := range objs {
+			aRef.delChild(obj)
+		}
+		aRef.setComparison(hier.Levels[index].Comparisons())
+		aRef.discs = true
+		for _, obj := range objs {
+			me.discByTrack(count, hier, index, aRef, obj.(*trackRef).track).addChild(obj)
+			// count change of disc object
+			*count++
+		}
+	}
+
+	// add track reference to object tree
+	me.discByTrack(count, hier, index, aRef, tRef.track).addChild(tRef)
+	// count change of disc object
+	*count++
+}
+
+// discByTrack returns the disc container below the album reference aRef that
+// track t belongs to. If it doesn't exist yet, it's created. count is
+// increased by the number of object changes that happened during this activity
+func (me *Content) discByTrack(count *uint32, hier *config.Hierarchy, index int, aRef *albumRef, t *track) *disc {
+	obj, exists := aRef.childByKey(discKey(t.tags.discNo))
+	if exists {
+		return obj.(*disc)
+	}
+
+	d := newDisc(me, aRef.album, t, hier.Levels[index].SortFields())
+	// set comparison functions for sorting of child objects
+	d.setComparison(hier.Levels[index+1].Comparisons())
+	// add disc to object tree
+	aRef.addChild(d)
+	// count creation of disc object
+	*count++
+
+	return d
+}
+
 // addTrackToFolderHierarchy adds track t to the folder hierarchy. ctr is the
 // corresponding hierarchy object (i.e. one level below root). count is
 // increased by the number of object changes that happened during this activity
@@ -0,0 +1,191 @@
+package content
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rjeczalik/notify"
+	"gitlab.com/mipimipi/muserv/src/internal/config"
+)
+
+// hybrid implements the updater interface to combine the notifier and the
+// scanner: Changes that are detected by inotify are applied as soon as their
+// directory has been quiet for quiet_period (but at latest after
+// update_interval, see batches.go), and full scans reconcile the content with
+// the music dirs after scan_interval (e.g. to detect changes that other hosts
+// made on network file systems). If inotify watchers cannot be added (e.g.
+// since the limit of watches is exhausted), hybrid falls back to scanning only
+type hybrid struct {
+	*notifier
+}
+
+// newHybrid creates a new hybrid instance for the content cnt
+func newHybrid(cnt *Content) *hybrid {
+	return &hybrid{notifier: newNotifier(cnt)}
+}
+
+// run implements the main control loop that listens to events from inotify and
+// that regularly triggers content updates and full scans
+func (me *hybrid) run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	log.Trace("running hybrid updater ...")
+
+	// extract config from context
+	cfg := ctx.Value(config.KeyCfg).(config.Cfg)
+
+	// errors of adding inotify watchers are received via channel fallbacks.
+	// In that case, hybrid falls back to scanning only
+	fallbacks := make(chan error, 1)
+	fallback := func(err error) {
+		select {
+		case fallbacks <- err:
+		default:
+		}
+	}
+
+	// add watchers for the music dirs (incl. the targets of symbolic links)
+	// and for the cache dir (see notifier)
+	chgs := make(chan notify.EventInfo, 1)
+	cacheChgs := make(chan notify.EventInfo, 1)
+	watched := make(map[string]struct{})
+	if errs := me.watch(cfg.Cnt.MusicDirs, chgs, watched, true); len(errs) > 0 {
+		fallback(errs[0])
+	} else if err := notify.Watch(cfg.CacheDir, cacheChgs, notify.All); err != nil {
+		fallback(errors.Wrapf(err, "cannot add inotify watcher for '%s'", cfg.CacheDir))
+	}
+
+	// changes are collected per directory (see notifier)
+	me.pending = newBatches(cfg.Cnt.QuietPeriod*time.Second, cfg.Cnt.UpdateInterval*time.Second)
+
+	// main control loop
+	var wg0 sync.WaitGroup
+	notifying := true
+	updTicker := time.NewTicker(batchCheckInterval)
+	scanTicker := time.NewTicker(cfg.Cnt.ScanInterval * time.Second)
+	availTicker := time.NewTicker(cfg.Cnt.UpdateInterval * time.Second)
+
+	// semaphore to ensure that only one content update run is done at any time
+	sema := make(chan struct{}, 1)
+
+	defer func() {
+		notify.Stop(chgs)
+		close(chgs)
+		notify.Stop(cacheChgs)
+		close(cacheChgs)
+		updTicker.Stop()
+		scanTicker.Stop()
+		availTicker.Stop()
+		close(me.errs)
+		close(me.updNotif)
+		close(me.upd)
+		close(sema)
+		log.Trace("hybrid updater stopped")
+	}()
+
+	for {
+		select {
+		case chg := <-chgs:
+			// receive inotify events
+			if notifying {
+				me.pending.add(chg, time.Now())
+			}
+
+		case chg := <-cacheChgs:
+			if notifying && me.cnt.overrides.isOverridesFile(chg.Path()) {
+				me.pending.add(chg, time.Now())
+			}
+
+		case now := <-updTicker.C:
+			// process the changes detected by inotify that are ready (see
+			// notifier)
+			if !notifying {
+				continue
+			}
+			select {
+			case sema <- struct{}{}:
+			default:
+				continue
+			}
+			changes := me.pending.ready(now)
+			if len(changes) == 0 {
+				<-sema
+				continue
+			}
+			wg0.Add(1)
+			go func() {
+				defer func() {
+					<-sema
+					wg0.Done()
+				}()
+
+				me.processChanges(ctx, changes)
+				if errs := me.watch(nil, chgs, watched, true); len(errs) > 0 {
+					fallback(errs[0])
+				}
+			}()
+
+		case <-availTicker.C:
+			// check if the music dirs are available (see notifier). If
+			// hybrid scans only, that's done by the scans
+			if !notifying {
+				continue
+			}
+			select {
+			case sema <- struct{}{}:
+			default:
+				continue
+			}
+			wg0.Add(1)
+			go func() {
+				defer func() {
+					<-sema
+					wg0.Done()
+				}()
+
+				if errs := me.checkMusicDirs(ctx, cfg, chgs, watched, true); len(errs) > 0 {
+					fallback(errs[0])
+				}
+			}()
+
+		case <-scanTicker.C:
+			// periodic full scan. If a scan or an update is still running,
+			// the tick is skipped
+			select {
+			case sema <- struct{}{}:
+			default:
+				continue
+			}
+			wg0.Add(1)
+			go func() {
+				defer func() {
+					<-sema
+					wg0.Done()
+				}()
+
+				fiDel, fiAdd := me.cnt.fullScan(cfg.Cnt.MusicDirs)
+				me.apply(ctx, fiDel, fiAdd)
+			}()
+
+		case err := <-fallbacks:
+			// stop inotify and scan with the update interval instead. Changes
+			// that have not been processed yet are covered by the scans
+			if !notifying {
+				continue
+			}
+			log.Errorf("falling back to update mode scan: %v", err)
+			notifying = false
+			notify.Stop(chgs)
+			notify.Stop(cacheChgs)
+			me.pending.reset()
+			scanTicker.Reset(cfg.Cnt.UpdateInterval * time.Second)
+
+		case <-ctx.Done():
+			// stop main control loop after last changes are processed
+			wg0.Wait()
+			return
+		}
+	}
+}
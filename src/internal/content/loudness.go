@@ -0,0 +1,328 @@
+package content
+
+// this file contains the logic to read loudness data (ReplayGain and R128 tags)
+// and to measure the loudness of tracks that don't have such tags. The
+// measurement is executed in the background. Its results are stored in a
+// cache file in the cache directory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	p "path"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/dhowden/tag"
+	"github.com/pkg/errors"
+)
+
+// reference loudness of ReplayGain 2.0 and of EBU R128 (in LUFS)
+const (
+	refLoudnessReplayGain = -18.0
+	refLoudnessR128       = -23.0
+)
+
+// gain contains the gain (in dB, relative to the ReplayGain reference
+// loudness) and the peak (as linear amplitude, 1.0 is full scale) of a track
+// or an album. valid is false if the gain is not known
+type gain struct {
+	gain  float64
+	peak  float64
+	valid bool
+}
+
+// loudness contains the loudness data of a track
+type loudness struct {
+	track gain
+	album gain
+}
+
+// reGain matches ReplayGain values, such as "-6.48 dB"
+var reGain = regexp.MustCompile(`^\s*([+-]?\d+(?:\.\d+)?)`)
+
+// readLoudness reads the loudness data from the tags m. ReplayGain tags are
+// preferred. Opus files contain R128 gains (relative to -23 LUFS, in Q7.8
+// format) instead
+func readLoudness(m tag.Metadata) (l loudness) {
+	l.track = readGain(m, "REPLAYGAIN_TRACK_GAIN", "REPLAYGAIN_TRACK_PEAK", "R128_TRACK_GAIN")
+	l.album = readGain(m, "REPLAYGAIN_ALBUM_GAIN", "REPLAYGAIN_ALBUM_PEAK", "R128_ALBUM_GAIN")
+	return
+}
+
+// readGain reads a gain and a peak from the tags m
+func readGain(m tag.Metadata, gainTag, peakTag, r128Tag string) (g gain) {
+	if match := reGain.FindStringSubmatch(rawTag(m, gainTag)); match != nil {
+		g.gain, _ = strconv.ParseFloat(match[1], 64)
+		g.peak, _ = strconv.ParseFloat(rawTag(m, peakTag), 64)
+		g.valid = true
+		return
+	}
+	if v, err := strconv.Atoi(rawTag(m, r128Tag)); err == nil {
+		g.gain = float64(v)/256 + refLoudnessR128 - refLoudnessReplayGain
+		g.valid = true
+	}
+	return
+}
+
+// isMeasurable returns true if the loudness of a track file can be measured by
+// muserv. That's the case for FLAC and WAV files
+func isMeasurable(path string) bool {
+	switch p.Ext(path) {
+	case ".flac", ".wav":
+		return true
+	}
+	return false
+}
+
+// measurement contains the result of a loudness measurement of a track
+type measurement struct {
+	MTime    int64   `json:"mtime"`    // UNIX time of last modification of track file
+	Loudness float64 `json:"loudness"` // integrated loudness in LUFS
+	Peak     float64 `json:"peak"`     // sample peak (linear amplitude)
+	Duration float64 `json:"duration"` // duration in seconds
+}
+
+// gain returns the ReplayGain gain and peak of a measurement
+func (me measurement) gain() gain {
+	return gain{refLoudnessReplayGain - me.Loudness, me.Peak, true}
+}
+
+// loudnessCache contains the loudness measurements per track file path. The
+// measurements are executed in the background. Thus, all access is
+// synchronized
+type loudnessCache struct {
+	mu      sync.RWMutex
+	path    string                 // path of cache file
+	data    map[string]measurement // measurements per track file path
+	queue   []loudnessRequest      // tracks that still have to be measured
+	pending chan struct{}          // signals that the queue is not empty
+	changed bool                   // cache has changed since it was written
+}
+
+// loudnessRequest is a request to measure the loudness of a track file
+type loudnessRequest struct {
+	path  string
+	mtime int64
+}
+
+// name of the loudness cache file in the cache directory
+const loudnessCacheFile = "loudness.json"
+
+// interval after that the loudness cache is written during a measurement run
+const loudnessCacheWriteInterval = 50
+
+// newLoudnessCache creates a new loudness cache and reads the cache file from
+// cacheDir (if it exists). Measurements for files that no longer exist are
+// removed
+func newLoudnessCache(cacheDir string) *loudnessCache {
+	lc := loudnessCache{
+		path:    p.Join(cacheDir, loudnessCacheFile),
+		data:    make(map[string]measurement),
+		pending: make(chan struct{}, 1),
+	}
+
+	b, err := os.ReadFile(lc.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Error(errors.Wrapf(err, "cannot read loudness cache '%s'", lc.path))
+		}
+		return &lc
+	}
+	if err = json.Unmarshal(b, &lc.data); err != nil {
+		log.Error(errors.Wrapf(err, "cannot parse loudness cache '%s'", lc.path))
+		lc.data = make(map[string]measurement)
+		return &lc
+	}
+	for path := range lc.data {
+		if _, err := os.Stat(path); err != nil {
+			delete(lc.data, path)
+			lc.changed = true
+		}
+	}
+
+	return &lc
+}
+
+// get returns the measurement for the track file path. ok is false if the file
+// has not been measured yet or if it has been modified since it was measured.
+// Only the time of the last modification of the track file itself is relevant,
+// so that changes of its sidecar files, its owner or its permissions don't
+// require the file to be measured again
+func (me *loudnessCache) get(path string, mtime int64) (m measurement, ok bool) {
+	me.mu.RLock()
+	defer me.mu.RUnlock()
+
+	m, ok = me.data[path]
+	return m, ok && m.MTime == mtime
+}
+
+// move transfers the measurement of the track file from to to after the file
+// has been moved. Since the time of last modification of a moved track is
+// unchanged (see moves.go), the measurement stays valid
+func (me *loudnessCache) move(from, to string) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	m, exists := me.data[from]
+	if !exists {
+		return
+	}
+	delete(me.data, from)
+	me.data[to] = m
+	me.changed = true
+}
+
+// request requests the measurement of the track file path. If there's already
+// an up-to-date measurement, nothing happens
+func (me *loudnessCache) request(path string, mtime int64) {
+	if _, ok := me.get(path, mtime); ok {
+		return
+	}
+
+	me.mu.Lock()
+	me.queue = append(me.queue, loudnessRequest{path, mtime})
+	me.mu.Unlock()
+
+	select {
+	case me.pending <- struct{}{}:
+	default:
+	}
+}
+
+// run measures the requested track files in the background until ctx is done.
+// The track files are read with the rate that limiter allows
+func (me *loudnessCache) run(ctx context.Context, wg *sync.WaitGroup, limiter *rateLimiter) {
+	defer wg.Done()
+
+	log.Trace("running loudness measurement ...")
+
+	for {
+		select {
+		case <-ctx.Done():
+			me.write()
+			log.Trace("stopped loudness measurement")
+			return
+		case <-me.pending:
+			me.measureQueue(ctx, limiter)
+			me.write()
+		}
+	}
+}
+
+// measureQueue measures the track files in the queue until it's empty or ctx
+// is done
+func (me *loudnessCache) measureQueue(ctx context.Context, limiter *rateLimiter) {
+	var n int
+	for ctx.Err() == nil {
+		me.mu.Lock()
+		if len(me.queue) == 0 {
+			me.mu.Unlock()
+			return
+		}
+		req := me.queue[0]
+		me.queue = me.queue[1:]
+		me.mu.Unlock()
+
+		log.Tracef("measuring loudness of '%s' ...", req.path)
+		result, err := measureLoudness(req.path, limiter)
+		if err != nil {
+			log.Error(errors.Wrapf(err, "cannot measure loudness of '%s'", req.path))
+			continue
+		}
+		result.MTime = req.mtime
+
+		me.mu.Lock()
+		me.data[req.path] = result
+		me.changed = true
+		me.mu.Unlock()
+
+		if n++; n%loudnessCacheWriteInterval == 0 {
+			me.write()
+		}
+	}
+}
+
+// write writes the cache file if the cache has been changed
+func (me *loudnessCache) write() {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	if !me.changed {
+		return
+	}
+	b, err := json.Marshal(me.data)
+	if err != nil {
+		log.Error(errors.Wrap(err, "cannot marshal loudness cache"))
+		return
+	}
+	if err = os.WriteFile(me.path, b, 0644); err != nil {
+		log.Error(errors.Wrapf(err, "cannot write loudness cache '%s'", me.path))
+		return
+	}
+	me.changed = false
+}
+
+// loudness returns the loudness data of track t. If its tags don't contain
+// loudness data, the measurement results are taken (if loudness measurement is
+// configured). The album gain is derived from the measurements of all tracks of
+// the album, provided that all of them have been measured
+func (me *track) loudness() (l loudness) {
+	l = me.tags.loudness
+	lc := me.cnt.loudnessCache
+	if lc == nil || l.track.valid {
+		return
+	}
+
+	m, ok := lc.get(me.path, me.mtime)
+	if !ok {
+		return
+	}
+	l.track = m.gain()
+
+	a, isAlbum := me.parent().(*album)
+	if !isAlbum {
+		return
+	}
+	var energy, duration, peak float64
+	for _, obj := range a.children.byID {
+		t := obj.(*track)
+		m, ok := lc.get(t.path, t.mtime)
+		if !ok {
+			return
+		}
+		energy += m.Duration * math.Pow(10, m.Loudness/10)
+		duration += m.Duration
+		peak = math.Max(peak, m.Peak)
+	}
+	if duration > 0 && energy > 0 {
+		l.album = measurement{Loudness: 10 * math.Log10(energy/duration), Peak: peak}.gain()
+	}
+	return
+}
+
+// marshalLoudness creates the DIDL representation of the loudness data l. Since
+// UPnP does not define properties for that, a desc element is used. It
+// contains the values in the format of the ReplayGain tags
+func marshalLoudness(l loudness) string {
+	if !l.track.valid && !l.album.valid {
+		return ""
+	}
+	s := "<desc id=\"replaygain\" nameSpace=\"urn:muserv:replaygain\" xmlns:rg=\"urn:muserv:replaygain\">"
+	if l.track.valid {
+		s += fmt.Sprintf("<rg:replaygain_track_gain>%+.2f dB</rg:replaygain_track_gain>", l.track.gain)
+		if l.track.peak > 0 {
+			s += fmt.Sprintf("<rg:replaygain_track_peak>%.6f</rg:replaygain_track_peak>", l.track.peak)
+		}
+	}
+	if l.album.valid {
+		s += fmt.Sprintf("<rg:replaygain_album_gain>%+.2f dB</rg:replaygain_album_gain>", l.album.gain)
+		if l.album.peak > 0 {
+			s += fmt.Sprintf("<rg:replaygain_album_peak>%.6f</rg:replaygain_album_peak>", l.album.peak)
+		}
+	}
+	return s + "</desc>"
+}
@@ -0,0 +1,90 @@
+package content
+
+// this file contains the logic for lyrics. Unsynchronized lyrics are read from
+// the tags of the track files (ID3v2 frame USLT, Vorbis comments LYRICS or
+// UNSYNCEDLYRICS, MP4 atom ©lyr). Time-synced lyrics are read from .lrc files
+// that are stored next to the track files and that have the same name
+
+import (
+	"os"
+	p "path"
+	"regexp"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// file extension of files with time-synced lyrics
+const lrcExt = ".lrc"
+
+// lrcPath returns the path of the .lrc file of the track file path
+func lrcPath(path string) string {
+	return strings.TrimSuffix(path, p.Ext(path)) + lrcExt
+}
+
+// readLyrics reads the unsynchronized lyrics from the tags m
+func readLyrics(m tag.Metadata) string {
+	if s := strings.TrimSpace(m.Lyrics()); len(s) > 0 {
+		return s
+	}
+	return rawTag(m, "UNSYNCEDLYRICS", "LYRICS")
+}
+
+// readTagLyrics reads the unsynchronized lyrics from the tags of the track file
+// path
+func readTagLyrics(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	m, err := tag.ReadFrom(f)
+	if err != nil {
+		return "", err
+	}
+	return readLyrics(m), nil
+}
+
+// regular expressions for LRC tags: time tags (e.g. "[01:23.45]"), ID tags
+// (e.g. "[ar:Artist]") and word time tags of the enhanced LRC format (e.g.
+// "<01:23.45>")
+var (
+	reLRCTime     = regexp.MustCompile(`^\[\d+:\d+(?:[.:]\d+)?\]`)
+	reLRCTag      = regexp.MustCompile(`\[[^\]]*\]`)
+	reLRCWordTime = regexp.MustCompile(`<\d+:\d+(?:[.:]\d+)?>`)
+)
+
+// unsyncLRC converts the content of an .lrc file into unsynchronized lyrics.
+// I.e. time tags and ID tags are removed
+func unsyncLRC(s string) string {
+	var lines []string
+	// remove byte order mark
+	s = strings.TrimPrefix(strings.ReplaceAll(s, "\r\n", "\n"), "\ufeff")
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		// skip lines that only contain ID tags
+		if strings.HasPrefix(line, "[") && !reLRCTime.MatchString(line) {
+			continue
+		}
+		line = reLRCTag.ReplaceAllString(line, "")
+		line = reLRCWordTime.ReplaceAllString(line, "")
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// readLRC reads the .lrc file of the track file path
+func readLRC(path string) (string, error) {
+	b, err := os.ReadFile(lrcPath(path))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// hasLyrics returns true if lyrics are available for track t (either from its
+// tags or from an .lrc file)
+func (me *track) hasLyrics() bool {
+	return me.tags.hasLyrics || me.hasLRC
+}
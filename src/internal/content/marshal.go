@@ -42,6 +42,10 @@ func marshalFuncMux(lvl config.LevelType, ctr container) objMarshalFunc {
 		return newAlbumArtistMarshalFunc(ctr)
 	case config.LvlArtist:
 		return newArtistMarshalFunc(ctr)
+	case config.LvlComposer:
+		return newPersonMarshalFunc(ctr, "Composer")
+	case config.LvlConductor:
+		return newPersonMarshalFunc(ctr, "Conductor")
 	case config.LvlGenre:
 		return newGenreMarshalFunc(ctr)
 	default:
@@ -65,11 +69,20 @@ func newAlbumMarshalFunc(ctr container, extPicturePath string) objMarshalFunc {
 			t = obj.(*track)
 			break
 		}
+		// the thumbnail from album.nfo is only taken if the tracks don't
+		// contain a picture
+		n := a.albumNFO()
 		if t.picID.valid {
 			fmt.Fprintf(buf, "<upnp:albumArtURI>%s</upnp:albumArtURI>", extPicturePath+fmt.Sprint(t.picID.id)+".jpg")
+		} else if uri := n.artURI(extPicturePath); len(uri) > 0 {
+			fmt.Fprintf(buf, "<upnp:albumArtURI>%s</upnp:albumArtURI>", html.EscapeString(uri))
 		}
-		if a.year > 0 {
-			fmt.Fprintf(buf, "<dc:date>%d-06-30</dc:date>", a.year)
+		fmt.Fprint(buf, marshalNFO(n))
+		if a.date.year > 0 {
+			fmt.Fprintf(buf, "<dc:date>%s</dc:date>", a.date)
+		}
+		if r := a.rating(); r > 0 {
+			fmt.Fprintf(buf, "<upnp:rating>%s</upnp:rating>", ratingString(r))
 		}
 		for i := 0; i < len(a.artists); i++ {
 			if len(a.artists[i]) == 0 {
@@ -129,6 +142,7 @@ func newAlbumArtistMarshalFunc(albumArtist container) objMarshalFunc {
 			fmt.Fprintf(buf, "<dc:title>%s</dc:title>", html.EscapeString(albumArtist.name()))
 			fmt.Fprintf(buf, "<upnp:class>object.container.person.musicArtist</upnp:class>")
 			fmt.Fprintf(buf, "<upnp:artist role=\"albumArtist\">%s</upnp:artist>", html.EscapeString(albumArtist.name()))
+			fmt.Fprint(buf, albumArtist.(*ctr).cnt.marshalArtistNFO(albumArtist.name()))
 			fmt.Fprintf(buf, "</container>")
 		case ModeChildren:
 			for i := first; i < last; i++ {
@@ -156,6 +170,7 @@ func newArtistMarshalFunc(artist container) objMarshalFunc {
 			fmt.Fprintf(buf, "<dc:title>%s</dc:title>", html.EscapeString(artist.name()))
 			fmt.Fprintf(buf, "<upnp:class>object.container.person.musicArtist</upnp:class>")
 			fmt.Fprintf(buf, "<upnp:artist>%s</upnp:artist>", html.EscapeString(artist.name()))
+			fmt.Fprint(buf, artist.(*ctr).cnt.marshalArtistNFO(artist.name()))
 			fmt.Fprintf(buf, "</container>")
 		case ModeChildren:
 			for i := first; i < last; i++ {
@@ -171,6 +186,33 @@ func newArtistMarshalFunc(artist container) objMarshalFunc {
 	}
 }
 
+// newPersonMarshalFunc creates a new marshal function for the container person
+// that represents a person with the given role (e.g. a composer)
+func newPersonMarshalFunc(person container, role string) objMarshalFunc {
+	return func(mode string, first, last int) []byte {
+		buf := new(bytes.Buffer)
+
+		switch mode {
+		case ModeMetadata:
+			fmt.Fprintf(buf, "<container id=\"%d\" parentID=\"%d\" restricted=\"1\" searchable=\"0\" childCount=\"%d\">", person.id(), person.parent().id(), person.numChildren())
+			fmt.Fprintf(buf, "<dc:title>%s</dc:title>", html.EscapeString(person.name()))
+			fmt.Fprintf(buf, "<upnp:class>object.container.person.musicArtist</upnp:class>")
+			fmt.Fprintf(buf, "<upnp:artist role=\"%s\">%s</upnp:artist>", role, html.EscapeString(person.name()))
+			fmt.Fprintf(buf, "</container>")
+		case ModeChildren:
+			for i := first; i < last; i++ {
+				_, err := buf.Write(person.childByIndex(i).marshal(ModeMetadata, 0, 0))
+				if err != nil {
+					log.Errorf("error marshalling person %d", person.id())
+					return []byte{}
+				}
+			}
+		}
+
+		return buf.Bytes()
+	}
+}
+
 // newContainerMarshalFunc creates a new marshal function for generic container
 // ctr
 func newContainerMarshalFunc(ctr container) objMarshalFunc {
@@ -204,6 +246,47 @@ func newContainerMarshalFunc(ctr container) objMarshalFunc {
 	}
 }
 
+// newDiscMarshalFunc creates a new marshal function for the disc container d.
+// extPicturePath is the external picture URL (i.e. the virtual path where
+// pictures can be requestd via HTTP).
+func newDiscMarshalFunc(d container, extPicturePath string) objMarshalFunc {
+	return func(mode string, first, last int) []byte {
+		buf := new(bytes.Buffer)
+
+		switch mode {
+		case ModeMetadata:
+			a := d.(*disc).album
+			fmt.Fprintf(buf, "<container id=\"%d\" parentID=\"%d\" restricted=\"1\" searchable=\"0\" childCount=\"%d\">", d.id(), d.parent().id(), d.numChildren())
+			fmt.Fprintf(buf, "<dc:title>%s</dc:title>", html.EscapeString(d.name()))
+			fmt.Fprint(buf, "<upnp:class>object.container.album.musicAlbum</upnp:class>")
+			fmt.Fprintf(buf, "<upnp:album>%s</upnp:album>", html.EscapeString(a.name()))
+			for _, obj := range a.children.byID {
+				if t := obj.(*track); t.picID.valid {
+					fmt.Fprintf(buf, "<upnp:albumArtURI>%s</upnp:albumArtURI>", extPicturePath+fmt.Sprint(t.picID.id)+".jpg")
+				}
+				break
+			}
+			for i := 0; i < len(a.artists); i++ {
+				if len(a.artists[i]) == 0 {
+					continue
+				}
+				fmt.Fprintf(buf, "<upnp:albumArtist>%s</upnp:albumArtist>", html.EscapeString(a.artists[i]))
+			}
+			fmt.Fprint(buf, "</container>")
+		case ModeChildren:
+			for i := first; i < last; i++ {
+				_, err := buf.Write(d.childByIndex(i).marshal(ModeMetadata, 0, 0))
+				if err != nil {
+					log.Errorf("error marshalling disc %d", d.id())
+					return []byte{}
+				}
+			}
+		}
+
+		return buf.Bytes()
+	}
+}
+
 // newFolderMarshalFunc creates a new marshal function for the folder
 // container folder
 func newFolderMarshalFunc(folder container) objMarshalFunc {
@@ -285,10 +368,10 @@ func newPlaylistMarshalFunc(playlist container) objMarshalFunc {
 
 // newTrackMarshalFunc creates a new marshal function for a track. itm is the
 // track item object, extMusicPath is the external music URL (i.e. the virtual
-// path where music tracks can be requestd via HTTP) and extPicturePath is the
+// path where music tracks can be requestd via HTTP), extPicturePath is the
 // external picture URL (i.e. the virtual path where pictures can be requestd
-// via HTTP).
-func newTrackMarshalFunc(itm item, extMusicPath, extPicturePath string) objMarshalFunc {
+// via HTTP) and extLyricsPath is the external lyrics URL.
+func newTrackMarshalFunc(itm item, extMusicPath, extPicturePath, extLyricsPath string) objMarshalFunc {
 	t := itm.(*track)
 	return func(mode string, first, last int) []byte {
 		buf := new(bytes.Buffer)
@@ -297,14 +380,24 @@ func newTrackMarshalFunc(itm item, extMusicPath, extPicturePath string) objMarsh
 		fmt.Fprint(buf, "<upnp:class>object.item.audioItem.musicTrack</upnp:class>")
 
 		// add meta data
-		if tags.year > 0 {
-			fmt.Fprintf(buf, "<dc:date>%d-06-30</dc:date>", tags.year)
+		if tags.date.year > 0 {
+			fmt.Fprintf(buf, "<dc:date>%s</dc:date>", tags.date)
 		}
-		for i := 0; i < len(tags.artists); i++ {
-			if len(tags.artists[i]) == 0 {
-				continue
+		// if the artist credit has been parsed, it's displayed as track artist
+		// as it is, and the featured artists are added as performers
+		if len(tags.artistCredit) > 0 {
+			fmt.Fprintf(buf, "<dc:creator>%s</dc:creator>", html.EscapeString(tags.artistCredit))
+			fmt.Fprintf(buf, "<upnp:artist>%s</upnp:artist>", html.EscapeString(tags.artistCredit))
+		} else {
+			for i := 0; i < len(tags.artists); i++ {
+				if len(tags.artists[i]) == 0 {
+					continue
+				}
+				fmt.Fprintf(buf, "<upnp:artist>%s</upnp:artist>", html.EscapeString(tags.artists[i]))
 			}
-			fmt.Fprintf(buf, "<upnp:artist>%s</upnp:artist>", html.EscapeString(tags.artists[i]))
+		}
+		for i := 0; i < len(tags.featured); i++ {
+			fmt.Fprintf(buf, "<upnp:artist role=\"Performer\">%s</upnp:artist>", html.EscapeString(tags.featured[i]))
 		}
 		for i := 0; i < len(tags.albumArtists); i++ {
 			if len(tags.albumArtists[i]) == 0 {
@@ -319,6 +412,27 @@ func newTrackMarshalFunc(itm item, extMusicPath, extPicturePath string) objMarsh
 			}
 			fmt.Fprintf(buf, "<upnp:artist role=\"Composer\">%s</upnp:artist>", html.EscapeString(tags.composers[i]))
 		}
+		if len(tags.work) > 0 || len(tags.movementName) > 0 {
+			fmt.Fprintf(buf, "<dc:description>%s</dc:description>", html.EscapeString(workDescription(tags)))
+		}
+		for i := 0; i < len(tags.conductors); i++ {
+			if len(tags.conductors[i]) == 0 {
+				continue
+			}
+			fmt.Fprintf(buf, "<upnp:artist role=\"Conductor\">%s</upnp:artist>", html.EscapeString(tags.conductors[i]))
+		}
+		for i := 0; i < len(tags.orchestras); i++ {
+			if len(tags.orchestras[i]) == 0 {
+				continue
+			}
+			fmt.Fprintf(buf, "<upnp:artist role=\"Orchestra\">%s</upnp:artist>", html.EscapeString(tags.orchestras[i]))
+		}
+		for i := 0; i < len(tags.performers); i++ {
+			if len(tags.performers[i]) == 0 {
+				continue
+			}
+			fmt.Fprintf(buf, "<upnp:artist role=\"Performer\">%s</upnp:artist>", html.EscapeString(tags.performers[i]))
+		}
 		for i := 0; i < len(tags.genres); i++ {
 			if len(tags.genres[i]) == 0 {
 				continue
@@ -328,12 +442,16 @@ func newTrackMarshalFunc(itm item, extMusicPath, extPicturePath string) objMarsh
 		if len(tags.album) > 0 {
 			fmt.Fprintf(buf, "<upnp:album>%s</upnp:album>", html.EscapeString(tags.album))
 		}
+		if tags.rating > 0 {
+			fmt.Fprintf(buf, "<upnp:rating>%s</upnp:rating>", ratingString(tags.rating))
+		}
 		if tags.trackNo > 0 {
 			fmt.Fprintf(buf, "<upnp:originalTrackNumber>%d</upnp:originalTrackNumber>", tags.trackNo)
 		}
 		if t.picID.valid {
 			fmt.Fprintf(buf, "<upnp:albumArtURI>%s</upnp:albumArtURI>", extPicturePath+fmt.Sprint(t.picID.id)+".jpg")
 		}
+		fmt.Fprint(buf, marshalLoudness(t.loudness()))
 		sizeAttr := ""
 		if !t.isExternal() {
 			sizeAttr = fmt.Sprintf("size=\"%d\"", t.size)
@@ -341,6 +459,13 @@ func newTrackMarshalFunc(itm item, extMusicPath, extPicturePath string) objMarsh
 		fmt.Fprintf(buf, "<res protocolInfo=\"http-get:*:%s:*\" %s>", html.EscapeString(t.mimeType), sizeAttr)
 		fmt.Fprint(buf, html.EscapeString(extMusicPath+fmt.Sprintf("%d", t.id())))
 		fmt.Fprint(buf, "</res>")
+		// lyrics are provided as additional text resources
+		if t.hasLyrics() {
+			fmt.Fprintf(buf, "<res protocolInfo=\"http-get:*:%s:*\">%s</res>", LyricsMimeType, html.EscapeString(extLyricsPath+fmt.Sprintf("%d", t.id())))
+		}
+		if t.hasLRC {
+			fmt.Fprintf(buf, "<res protocolInfo=\"http-get:*:%s:*\">%s</res>", LRCMimeType, html.EscapeString(extLyricsPath+fmt.Sprintf("%d", t.id())+lrcExt))
+		}
 
 		return buf.Bytes()
 	}
@@ -0,0 +1,187 @@
+package content
+
+// this file contains the logic to detect tracks that have been moved or
+// renamed. Without it, a moved track file would be deleted and added as a new
+// track, i.e. it would get a new object ID and it would lose its playlist
+// references. A track is regarded as moved if a deleted and an added track file
+// have the same device and inode number and if size and time of last
+// modification are unchanged. The track object is kept and only its path is
+// updated. The tags are only read again if settings that depend on the path
+// (path templates, directory rules, tag overrides, charsets and compilation
+// directories) differ for the new path
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	p "path"
+	"strings"
+	"sync"
+	"syscall"
+
+	"gitlab.com/go-utilities/hash"
+)
+
+// fileID identifies a file by device and inode number
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// move is a track that has been moved. ti is the file info of the new path
+type move struct {
+	t  *track
+	ti trackInfo
+}
+
+// fileIDOf returns the file ID and the UNIX time of the last modification of
+// the file with file info info
+func fileIDOf(info os.FileInfo) (id fileID, mtime int64) {
+	if info == nil {
+		return
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	// the explicit casts are required since the types of these fields differ
+	// between architectures
+	return fileID{uint64(st.Dev), uint64(st.Ino)}, info.ModTime().Unix()
+}
+
+// pathHash returns a hash of the settings that depend on path and that
+// influence the tags of a track
+func (me *Content) pathHash(path string) uint64 {
+	cfg := me.cfg
+
+	var (
+		values   map[string]string
+		override bool
+	)
+	if tmpl := cfg.Cnt.PathTemplate(path); tmpl != nil {
+		values, _ = tmpl.Match(path)
+		override = tmpl.Overrides()
+	}
+	ds, _ := me.rules.settings(path)
+	o, _ := me.overrides.get(path)
+	dsJSON, _ := json.Marshal(ds)
+	oJSON, _ := json.Marshal(o)
+
+	// the detection of compilations by the number of artists depends on the
+	// other tracks in the directory
+	var dir string
+	if cfg.Cnt.Compilations.MinArtists > 0 {
+		dir = p.Dir(path)
+	}
+
+	return hash.HashUint64("%v%t%s%s%s%t%s",
+		values,
+		override,
+		dsJSON,
+		oJSON,
+		fmt.Sprint(cfg.Cnt.CharsetRepair.DirCharset(path)),
+		cfg.Cnt.Compilations.IsCompilationDir(path),
+		dir,
+	)
+}
+
+// detectMoves determines the tracks that have been moved, i.e. for which there
+// is a deleted and an added track file that are the same file. These are
+// removed from fiDel and fiAdd and returned as moves
+func (me *Content) detectMoves(fiDel, fiAdd *fileInfos) (moves []move) {
+	// deleted tracks per file ID
+	deleted := make(map[fileID]*track)
+	for _, fi := range *fiDel {
+		if fi.kind() != infoTrack {
+			continue
+		}
+		t, exists := me.tracks[fi.path()]
+		if !exists || t.isExternal() || t.file == (fileID{}) {
+			continue
+		}
+		deleted[t.file] = t
+	}
+	if len(deleted) == 0 {
+		return
+	}
+
+	moved := make(map[string]struct{})
+	var adds fileInfos
+	for _, fi := range *fiAdd {
+		if fi.kind() == infoTrack {
+			// the file info is not taken from fi since that fails fatally if
+			// the file doesn't exist anymore
+			info, err := os.Stat(fi.path())
+			if err == nil {
+				id, mtime := fileIDOf(info)
+				t, exists := deleted[id]
+				if exists && t.path != fi.path() && t.size == info.Size() && t.mtime == mtime {
+					moves = append(moves, move{t, fi.(trackInfo)})
+					moved[t.path] = struct{}{}
+					delete(deleted, id)
+					continue
+				}
+			}
+		}
+		adds = append(adds, fi)
+	}
+	*fiAdd = adds
+
+	var dels fileInfos
+	for _, fi := range *fiDel {
+		if _, exists := moved[fi.path()]; exists && fi.kind() == infoTrack {
+			continue
+		}
+		dels = append(dels, fi)
+	}
+	*fiDel = dels
+
+	return
+}
+
+// moveTrack changes the path of the track t to the path of ti. t keeps its
+// object ID and its playlist references
+func (me *Content) moveTrack(wg *sync.WaitGroup, count *uint32, t *track, ti trackInfo) (err error) {
+	log.Tracef("track '%s' has been moved to '%s'", t.path, ti.path())
+
+	// remove t from its album and the hierarchies and from the old path
+	me.detachTrack(count, t, false)
+	delete(me.tracks, t.path)
+	me.paths.del(t.path)
+
+	// since size and time of last modification are unchanged, the track keeps
+	// its time of last change. Only the ctime of the file, which has been
+	// changed by the move, is taken over to detect later changes
+	if me.loudnessCache != nil {
+		me.loudnessCache.move(t.path, ti.path())
+	}
+	me.addedCache.move(t.path, ti.path())
+	t.path = ti.path()
+	t.fileChange = ti.lastChange()
+
+	// the tags are only read again if the settings for the new path differ
+	if h := me.pathHash(t.path); h != t.pathHash {
+		tgs, picture, err := ti.metadata(me)
+		if err != nil {
+			log.Fatal(err)
+			return err
+		}
+		me.completeAlbumArtists(t.path, tgs)
+		t.tags = tgs
+		t.n, t.k, t.sf = tgs.title, hash.HashUint64(tgs.title), []string{strings.ToLower(tgs.title)}
+		t.pathHash = h
+
+		me.pictures.add(wg, picture, &t.picID)
+	}
+
+	t.setPathData()
+
+	me.tracks.add(t)
+	me.paths.add(t.path, infoTrack)
+
+	// count change of track object
+	*count++
+
+	me.addTrackToAlbum(count, t)
+	return me.addTrackToHierarchies(count, t)
+}
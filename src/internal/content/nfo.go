@@ -0,0 +1,210 @@
+package content
+
+// this file contains the logic for .nfo files in the format of Kodi. An
+// album.nfo file in the directory of an album contains additional data for the
+// album. An artist.nfo file in the directory of an artist (i.e. typically the
+// parent directory of the album directories) contains additional data for the
+// artist. Descriptions (album reviews and artist biographies), styles, moods
+// and thumbnails are taken from these files
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"os"
+	p "path"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// names of .nfo files
+const (
+	albumNFOFile  = "album.nfo"
+	artistNFOFile = "artist.nfo"
+)
+
+// nfo contains the data of an .nfo file
+type nfo struct {
+	lastChange  int64     // UNIX time of last change of the file
+	name        string    // artist name (only for artist.nfo)
+	description string    // album review or artist biography
+	genres      []string  // styles and moods
+	thumbURL    string    // URL of the thumbnail if it's not a local file
+	picID       nonePicID // ID of the thumbnail if it's a local file
+}
+
+// nfoXML is the XML structure of album.nfo and artist.nfo files. Only the
+// elements that are used by muserv are contained
+type nfoXML struct {
+	Name      string   `xml:"name"`
+	Review    string   `xml:"review"`
+	Biography string   `xml:"biography"`
+	Styles    []string `xml:"style"`
+	Moods     []string `xml:"mood"`
+	Thumbs    []struct {
+		Aspect string `xml:"aspect,attr"`
+		Path   string `xml:",chardata"`
+	} `xml:"thumb"`
+}
+
+// albumNFOPaths returns the possible paths of the album.nfo file of the track
+// file path. That's the directory of the track and - for albums that are split
+// into one directory per disc - its parent directory
+func albumNFOPaths(path string) []string {
+	dir := p.Dir(path)
+	return []string{p.Join(dir, albumNFOFile), p.Join(p.Dir(dir), albumNFOFile)}
+}
+
+// artistNFOPaths returns the possible paths of the artist.nfo file of the
+// track file path. That's the parent directory of the directory of the track
+// and - for albums that are split into one directory per disc - the parent
+// directory of that
+func artistNFOPaths(path string) []string {
+	dir := p.Dir(p.Dir(path))
+	return []string{p.Join(dir, artistNFOFile), p.Join(p.Dir(dir), artistNFOFile)}
+}
+
+// isNFO returns true if path is an album.nfo or artist.nfo file
+func isNFO(path string) bool {
+	name := p.Base(path)
+	return name == albumNFOFile || name == artistNFOFile
+}
+
+// nfoOf returns the first .nfo file of paths that exists. The files are
+// cached and only read again if they have been changed. nil is returned if none
+// of the files exists
+func (me *Content) nfoOf(paths []string) *nfo {
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		lastChange := int64(info.Sys().(*syscall.Stat_t).Ctim.Sec)
+
+		n, exists := me.nfos[path]
+		if !exists || n.lastChange != lastChange {
+			n = me.readNFO(path, lastChange)
+			me.nfos[path] = n
+		}
+		return n
+	}
+	return nil
+}
+
+// readNFO reads the .nfo file path. If the file cannot be parsed, the error is
+// logged and an empty nfo is returned
+func (me *Content) readNFO(path string, lastChange int64) *nfo {
+	n := nfo{lastChange: lastChange}
+
+	var x nfoXML
+	b, err := os.ReadFile(path)
+	if err == nil {
+		err = xml.Unmarshal(b, &x)
+	}
+	if err != nil {
+		log.Error(errors.Wrapf(err, "cannot read '%s'", path))
+		return &n
+	}
+
+	n.name = strings.TrimSpace(x.Name)
+	if len(n.name) == 0 {
+		n.name = p.Base(p.Dir(path))
+	}
+	n.description = strings.TrimSpace(x.Review + x.Biography)
+	for _, g := range append(x.Styles, x.Moods...) {
+		if g = strings.TrimSpace(g); len(g) > 0 {
+			n.genres = append(n.genres, g)
+		}
+	}
+
+	// the first thumbnail that is no banner, logo etc. is taken. It's either
+	// a URL or the path of a local file (relative to the .nfo file)
+	for _, thumb := range x.Thumbs {
+		thumbPath := strings.TrimSpace(thumb.Path)
+		if len(thumbPath) == 0 || (len(thumb.Aspect) > 0 && thumb.Aspect != "thumb") {
+			continue
+		}
+		if strings.HasPrefix(thumbPath, "http://") || strings.HasPrefix(thumbPath, "https://") {
+			n.thumbURL = thumbPath
+			break
+		}
+		if !p.IsAbs(thumbPath) {
+			thumbPath = p.Join(p.Dir(path), thumbPath)
+		}
+		if b, err = os.ReadFile(thumbPath); err == nil {
+			n.picID, err = me.pictures.addData(b)
+		}
+		if err != nil {
+			log.Error(errors.Wrapf(err, "cannot read thumbnail '%s'", thumbPath))
+		}
+		break
+	}
+
+	return &n
+}
+
+// artURI returns the URI of the thumbnail of the .nfo file. extPicturePath is
+// the external picture URL. If there's no thumbnail, "" is returned
+func (me *nfo) artURI(extPicturePath string) string {
+	if me == nil {
+		return ""
+	}
+	if me.picID.valid {
+		return extPicturePath + fmt.Sprint(me.picID.id) + ".jpg"
+	}
+	return me.thumbURL
+}
+
+// firstParagraph returns the first paragraph of the text s
+func firstParagraph(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	if i := strings.Index(s, "\n\n"); i >= 0 {
+		return strings.TrimSpace(s[:i])
+	}
+	return s
+}
+
+// marshalNFO creates the DIDL representation of the description and the
+// genres of the .nfo file n. The first paragraph of the description is taken
+// as short description
+func marshalNFO(n *nfo) string {
+	if n == nil {
+		return ""
+	}
+	var s string
+	if len(n.description) > 0 {
+		s += "<dc:description>" + html.EscapeString(firstParagraph(n.description)) + "</dc:description>"
+		s += "<upnp:longDescription>" + html.EscapeString(n.description) + "</upnp:longDescription>"
+	}
+	for _, g := range n.genres {
+		s += "<upnp:genre>" + html.EscapeString(g) + "</upnp:genre>"
+	}
+	return s
+}
+
+// albumNFO returns the album.nfo data of the album. That's the data of the
+// first of its tracks that has an album.nfo file
+func (me *album) albumNFO() *nfo {
+	for _, obj := range me.children.byID {
+		if n := obj.(*track).albumNFO; n != nil {
+			return n
+		}
+	}
+	return nil
+}
+
+// marshalArtistNFO creates the DIDL representation of the artist.nfo data of
+// the artist with the given name
+func (me *Content) marshalArtistNFO(name string) string {
+	n, exists := me.artistNFOs[strings.ToLower(name)]
+	if !exists {
+		return ""
+	}
+	s := marshalNFO(n)
+	if uri := n.artURI(me.extPicturePath); len(uri) > 0 {
+		s = "<upnp:albumArtURI>" + html.EscapeString(uri) + "</upnp:albumArtURI>" + s
+	}
+	return s
+}
@@ -0,0 +1,97 @@
+package content
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gitlab.com/mipimipi/muserv/src/internal/config"
+)
+
+// id3v1Genres contains the genres of ID3v1 (incl. the Winamp extensions). The
+// index of a genre is its numeric ID3v1 genre code
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge",
+	"Hip-Hop", "Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B",
+	"Rap", "Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska",
+	"Death Metal", "Pranks", "Soundtrack", "Euro-Techno", "Ambient",
+	"Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance", "Classical",
+	"Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative",
+	"Instrumental Pop", "Instrumental Rock", "Ethnic", "Gothic", "Darkwave",
+	"Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap",
+	"Pop/Funk", "Jungle", "Native American", "Cabaret", "New Wave",
+	"Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi", "Tribal",
+	"Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll",
+	"Hard Rock", "Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion",
+	"Bebop", "Latin", "Revival", "Celtic", "Bluegrass", "Avantgarde",
+	"Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock",
+	"Slow Rock", "Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour",
+	"Speech", "Chanson", "Opera", "Chamber Music", "Sonata", "Symphony",
+	"Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam", "Club",
+	"Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul",
+	"Freestyle", "Duet", "Punk Rock", "Drum Solo", "A Cappella", "Euro-House",
+	"Dance Hall", "Goa", "Drum & Bass", "Club-House", "Hardcore Techno",
+	"Terror", "Indie", "BritPop", "Negerpunk", "Polsk Punk", "Beat",
+	"Christian Gangsta Rap", "Heavy Metal", "Black Metal", "Crossover",
+	"Contemporary Christian", "Christian Rock", "Merengue", "Salsa",
+	"Thrash Metal", "Anime", "Jpop", "Synthpop", "Abstract", "Art Rock",
+	"Baroque", "Bhangra", "Big Beat", "Breakbeat", "Chillout", "Downtempo",
+	"Dub", "EBM", "Eclectic", "Electro", "Electroclash", "Emo", "Experimental",
+	"Garage", "Global", "IDM", "Illbient", "Industro-Goth", "Jam Band",
+	"Krautrock", "Leftfield", "Lounge", "Math Rock", "New Romantic",
+	"Nu-Breakz", "Post-Punk", "Post-Rock", "Psytrance", "Shoegaze",
+	"Space Rock", "Trop Rock", "World Music", "Neoclassical", "Audiobook",
+	"Audio Theatre", "Neue Deutsche Welle", "Podcast", "Indie Rock",
+	"G-Funk", "Dubstep", "Garage Rock", "Psybient",
+}
+
+// reID3v1Genre matches numeric ID3v1 genres, such as "17" or "(17)". The
+// latter can be followed by a refinement (e.g. "(17)Rock")
+var reID3v1Genre = regexp.MustCompile(`^(?:\((\d+|RX|CR)\)(.*)|(\d+))$`)
+
+// id3v1Genre translates a numeric ID3v1 genre into its name. If genre is not
+// numeric or the code is unknown, genre is returned unchanged
+func id3v1Genre(genre string) string {
+	m := reID3v1Genre.FindStringSubmatch(genre)
+	if m == nil {
+		return genre
+	}
+	// if there's a refinement it is preferred over the numeric code
+	if len(strings.TrimSpace(m[2])) > 0 {
+		return strings.TrimSpace(m[2])
+	}
+	code := m[1] + m[3]
+	switch code {
+	case "RX":
+		return "Remix"
+	case "CR":
+		return "Cover"
+	}
+	i, err := strconv.Atoi(code)
+	if err != nil || i >= len(id3v1Genres) {
+		return genre
+	}
+	return id3v1Genres[i]
+}
+
+// normalizeEntries applies the tag rules to the values of tag tg. First,
+// numeric ID3v1 genres are translated (if that's configured), then the rewrite
+// rules are applied and finally the aliases are resolved. Duplicates that
+// result from the normalization are removed
+func normalizeEntries(rules *config.TagRules, tg config.TagName, values []string) (normalized []string) {
+	exists := make(map[string]struct{})
+	for _, v := range values {
+		if tg == config.TagGenre && *rules.ID3v1Genres {
+			v = id3v1Genre(v)
+		}
+		v = strings.TrimSpace(rules.Alias(tg, rules.Rewrite(tg, v)))
+		if _, ok := exists[v]; ok {
+			continue
+		}
+		exists[v] = struct{}{}
+		normalized = append(normalized, v)
+	}
+	return
+}
@@ -0,0 +1,86 @@
+package content
+
+import (
+	"reflect"
+	"testing"
+
+	"gitlab.com/mipimipi/muserv/src/internal/config"
+)
+
+func TestID3v1Genre(t *testing.T) {
+	tests := []struct {
+		genre string
+		want  string
+	}{
+		{"17", "Rock"},
+		{"(17)", "Rock"},
+		{"0", "Blues"},
+		{"(0)", "Blues"},
+		{"191", "Psybient"},
+		// refinements are preferred over the numeric code
+		{"(17)Hard Rock", "Hard Rock"},
+		{"(17) Hard Rock ", "Hard Rock"},
+		{"(17) ", "Rock"},
+		{"(RX)", "Remix"},
+		{"(CR)", "Cover"},
+		{"(RX)Club Mix", "Club Mix"},
+		// unknown codes and other values are returned unchanged
+		{"192", "192"},
+		{"(999)", "(999)"},
+		{"RX", "RX"},
+		{"Rock", "Rock"},
+		{"17 Rock", "17 Rock"},
+		{"(17", "(17"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := id3v1Genre(tt.genre); got != tt.want {
+			t.Errorf("id3v1Genre(%q) = %q, want %q", tt.genre, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeEntries(t *testing.T) {
+	on, off := true, false
+	tests := []struct {
+		name   string
+		rules  config.TagRules
+		tg     config.TagName
+		values []string
+		want   []string
+	}{
+		{
+			name:   "ID3v1 genres",
+			rules:  config.TagRules{ID3v1Genres: &on},
+			tg:     config.TagGenre,
+			values: []string{"(17)", "Jazz", "8"},
+			want:   []string{"Rock", "Jazz"},
+		},
+		{
+			name:   "ID3v1 genres switched off",
+			rules:  config.TagRules{ID3v1Genres: &off},
+			tg:     config.TagGenre,
+			values: []string{"(17)", "8"},
+			want:   []string{"(17)", "8"},
+		},
+		{
+			name:   "ID3v1 genres only for genre",
+			rules:  config.TagRules{ID3v1Genres: &on},
+			tg:     config.TagArtist,
+			values: []string{"17"},
+			want:   []string{"17"},
+		},
+		{
+			name:   "duplicates and spaces",
+			rules:  config.TagRules{ID3v1Genres: &on},
+			tg:     config.TagArtist,
+			values: []string{" A ", "B", "A"},
+			want:   []string{"A", "B"},
+		},
+	}
+	for _, tt := range tests {
+		if got := normalizeEntries(&tt.rules, tt.tg, tt.values); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: normalizeEntries(%q) = %q, want %q", tt.name, tt.values, got, tt.want)
+		}
+	}
+}
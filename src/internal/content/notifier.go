@@ -2,6 +2,7 @@ package content
 
 import (
 	"context"
+	"os"
 	"path/filepath"
 	"sort"
 	"sync"
@@ -16,24 +17,21 @@ import (
 // notifier implements the updater interface to enable content updates based on
 // file system changes detected by inotify
 type notifier struct {
-	changes      []notify.EventInfo
-	mutChanges   sync.Mutex
-	errs         chan error
-	updNotif     chan UpdateNotification
-	upd          chan struct{}
-	filesByPaths func([]string) *fileInfos
-	update       func(context.Context, *fileInfos, *fileInfos) (uint32, error)
+	pending  *batches
+	errs     chan error
+	updNotif chan UpdateNotification
+	upd      chan struct{}
+	cnt      *Content
 }
 
-// newNotifier creates a new instance of notifier
-func newNotifier(filesByPaths func([]string) *fileInfos, update func(context.Context, *fileInfos, *fileInfos) (uint32, error)) *notifier {
+// newNotifier creates a new instance of notifier for the content cnt
+func newNotifier(cnt *Content) *notifier {
 	nf := new(notifier)
 
 	nf.errs = make(chan error)
 	nf.updNotif = make(chan UpdateNotification)
 	nf.upd = make(chan struct{})
-	nf.filesByPaths = filesByPaths
-	nf.update = update
+	nf.cnt = cnt
 
 	return nf
 }
@@ -48,19 +46,33 @@ func (me *notifier) run(ctx context.Context, wg *sync.WaitGroup) {
 	// extract config from context
 	cfg := ctx.Value(config.KeyCfg).(config.Cfg)
 
-	// add watcher for inotify events for music dir. Changes can be received via
-	// channel chgs
+	// add watchers for inotify events for the music dirs and for the targets
+	// of symbolic links that have been followed. Changes can be received via
+	// channel chgs. Since new links can be added at any time, the watchers for
+	// link targets are added again after each update
 	chgs := make(chan notify.EventInfo, 1)
-	for _, dir := range cfg.Cnt.MusicDirs {
-		if err := notify.Watch(filepath.Join(dir, "..."), chgs, notify.All); err != nil {
-			err = errors.Wrapf(err, "cannot add inotify watcher for '%s'", dir)
-			me.errs <- err
-		}
+	watched := make(map[string]struct{})
+	for _, err := range me.watch(cfg.Cnt.MusicDirs, chgs, watched, false) {
+		me.errs <- err
+	}
+
+	// add watcher for the cache dir, since it contains the central tag
+	// overrides file. Other changes in the cache dir are ignored
+	cacheChgs := make(chan notify.EventInfo, 1)
+	if err := notify.Watch(cfg.CacheDir, cacheChgs, notify.All); err != nil {
+		err = errors.Wrapf(err, "cannot add inotify watcher for '%s'", cfg.CacheDir)
+		me.errs <- err
 	}
 
+	// changes are collected per directory and processed once the directory
+	// has been quiet for the quiet period, but at latest after the update
+	// interval
+	me.pending = newBatches(cfg.Cnt.QuietPeriod*time.Second, cfg.Cnt.UpdateInterval*time.Second)
+
 	// main control loop
 	var wg0 sync.WaitGroup
-	ticker := time.NewTicker(cfg.Cnt.UpdateInterval * time.Second)
+	ticker := time.NewTicker(batchCheckInterval)
+	availTicker := time.NewTicker(cfg.Cnt.UpdateInterval * time.Second)
 
 	// semaphore to ensure that only one content update run is done at any time
 	sema := make(chan struct{}, 1)
@@ -68,7 +80,10 @@ func (me *notifier) run(ctx context.Context, wg *sync.WaitGroup) {
 	defer func() {
 		notify.Stop(chgs)
 		close(chgs)
+		notify.Stop(cacheChgs)
+		close(cacheChgs)
 		ticker.Stop()
+		availTicker.Stop()
 		close(me.errs)
 		close(me.updNotif)
 		close(me.upd)
@@ -80,21 +95,56 @@ func (me *notifier) run(ctx context.Context, wg *sync.WaitGroup) {
 		select {
 		case chg := <-chgs:
 			// receive inotify events
-			me.mutChanges.Lock()
-			me.changes = append(me.changes, chg)
-			me.mutChanges.Unlock()
+			me.pending.add(chg, time.Now())
+
+		case chg := <-cacheChgs:
+			if me.cnt.overrides.isOverridesFile(chg.Path()) {
+				me.pending.add(chg, time.Now())
+			}
+
+		case now := <-ticker.C:
+			// process the changes that are ready. If an update is still
+			// running, that's done with one of the next ticks
+			select {
+			case sema <- struct{}{}:
+			default:
+				continue
+			}
+			changes := me.pending.ready(now)
+			if len(changes) == 0 {
+				<-sema
+				continue
+			}
+			wg0.Add(1)
+			go func() {
+				defer func() {
+					<-sema
+					wg0.Done()
+				}()
 
-		case <-ticker.C:
-			// periodic update trigger
+				me.processChanges(ctx, changes)
+				for _, err := range me.watch(nil, chgs, watched, false) {
+					log.Error(err)
+				}
+			}()
+
+		case <-availTicker.C:
+			// check if the music dirs are available
+			select {
+			case sema <- struct{}{}:
+			default:
+				continue
+			}
 			wg0.Add(1)
 			go func() {
-				sema <- struct{}{}
 				defer func() {
 					<-sema
 					wg0.Done()
 				}()
 
-				me.processChanges(ctx, cfg)
+				for _, err := range me.checkMusicDirs(ctx, cfg, chgs, watched, false) {
+					log.Error(err)
+				}
 			}()
 
 		case <-ctx.Done():
@@ -115,30 +165,81 @@ func (me *notifier) updateNotification() <-chan UpdateNotification {
 	return me.updNotif
 }
 
+// checkMusicDirs checks the availability of the music dirs. Music dirs that
+// are available again are scanned since changes might have been missed. In
+// addition, the watchers are added again, since the existing watchers can
+// refer to the directories that were mounted before. The errors of adding the
+// watchers are returned (see watch)
+func (me *notifier) checkMusicDirs(ctx context.Context, cfg config.Cfg, chgs chan<- notify.EventInfo, watched map[string]struct{}, failFast bool) []error {
+	_ = me.cnt.avail.availableDirs(cfg.Cnt.MusicDirs, me.cnt.paths.hasAny)
+	resumed := me.cnt.avail.takeResumed()
+	if len(resumed) == 0 {
+		return nil
+	}
+
+	notify.Stop(chgs)
+	for path := range watched {
+		delete(watched, path)
+	}
+	errs := me.watch(cfg.Cnt.MusicDirs, chgs, watched, failFast)
+
+	fiDel, fiAdd := me.cnt.fullScan(resumed)
+	me.apply(ctx, fiDel, fiAdd)
+
+	return errs
+}
+
+// watch adds watchers for the directories dirs and for the targets of symbolic
+// links. Directories are watched recursively. The events are sent to chgs.
+// watched contains the paths that are already watched. They are skipped, as
+// well as paths that don't exist (e.g. link targets that have been removed in
+// the meantime). If failFast is true, watch
+// stops at the first error. Otherwise, the remaining paths are processed and
+// all errors are returned
+func (me *notifier) watch(dirs []string, chgs chan<- notify.EventInfo, watched map[string]struct{}, failFast bool) (errs []error) {
+	for _, path := range append(append([]string{}, dirs...), me.cnt.links.targetPaths()...) {
+		if _, exists := watched[path]; exists {
+			continue
+		}
+		watchPath := path
+		isDir, err := f.IsDir(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				log.Tracef("'%s' is not watched since it doesn't exist", path)
+				continue
+			}
+			err = errors.Wrapf(err, "cannot add inotify watcher for '%s'", path)
+		} else {
+			if isDir {
+				watchPath = filepath.Join(path, "...")
+			}
+			if err = notify.Watch(watchPath, chgs, notify.All); err != nil {
+				err = errors.Wrapf(err, "cannot add inotify watcher for '%s'", path)
+			}
+		}
+		if err != nil {
+			if errs = append(errs, err); failFast {
+				return
+			}
+			continue
+		}
+		watched[path] = struct{}{}
+	}
+	return
+}
+
 // processChanges detects which files need to either be deleted from or added
 // to the muserv content based on the file system changes that have been
 // observed by inotify. The DB is adjusted accordingly.
-func (me *notifier) processChanges(ctx context.Context, cfg config.Cfg) {
+func (me *notifier) processChanges(ctx context.Context, changes []notify.EventInfo) {
 	log.Trace("processing file system notifications ...")
 
-	// check if there are changes at all. If yes copy changes to local table
-	// protected by a mutex to avoid inconsistencies
-	noChanges := false
-	var changes []notify.EventInfo
-	me.mutChanges.Lock()
-	if len(me.changes) > 0 {
-		changes = make([]notify.EventInfo, len(me.changes))
-		copy(changes, me.changes)
-		me.changes = nil
-	} else {
-		noChanges = true
-	}
-	me.mutChanges.Unlock()
-	if noChanges {
-		log.Trace("no changes to process")
-		return
-	}
-	log.Trace("changes occurred: processing ...")
+	// extract config from context
+	cfg := ctx.Value(config.KeyCfg).(config.Cfg)
+
+	// changes in music dirs that are not available are skipped to keep their
+	// content
+	_ = me.cnt.avail.availableDirs(cfg.Cnt.MusicDirs, me.cnt.paths.hasAny)
 
 	// map for storing changed paths that were already processed (for some
 	// changes notify delivers the same path multiple times)
@@ -150,47 +251,67 @@ func (me *notifier) processChanges(ctx context.Context, cfg config.Cfg) {
 	// (which is an indicator that they might have to be added to the content)
 	var fiCnt, fiDir fileInfos
 	for _, chg := range changes {
-		// don't process a changed path twice
-		if _, processed := processed[chg.Path()]; processed {
-			continue
+		// changes in the targets of symbolic links are mapped to the paths
+		// of the links. Changes of sidecar files are treated as changes of
+		// the tracks that they belong to
+		var paths []string
+		for _, path := range me.cnt.links.logicalPaths(chg.Path()) {
+			if me.cnt.isSidecar(path) {
+				paths = append(paths, me.cnt.pathsOfSidecar(path)...)
+				continue
+			}
+			paths = append(paths, path)
 		}
-		processed[chg.Path()] = struct{}{}
 
-		log.Tracef("%s :: %s", chg.Event().String(), chg.Path())
+		for _, path := range paths {
+			// don't process a changed path twice
+			if _, processed := processed[path]; processed {
+				continue
+			}
+			processed[path] = struct{}{}
 
-		// collect all changed files that are contained in music dir
-		exists, err := f.Exists(chg.Path())
-		if err != nil {
-			err = errors.Wrapf(err, "cannot process changed path '%s'", chg.Path())
-			log.Error(err)
-			continue
-		}
-		if exists {
-			// if it's a directory: Recursively expand it to the (supported)
-			// files that are contained in that directory. Otherwise, go
-			// forward with the single file
-			isDir, err := f.IsDir(chg.Path())
+			if dir := cfg.Cnt.MusicDir(path); len(dir) > 0 && !me.cnt.avail.isAvailable(dir) {
+				log.Tracef("'%s' is skipped since music dir '%s' is unavailable", path, dir)
+				continue
+			}
+
+			log.Tracef("%s :: %s", chg.Event().String(), path)
+
+			// collect all changed files that are contained in music dir
+			exists, err := f.Exists(path)
 			if err != nil {
-				err = errors.Wrapf(err, "cannot process changed path '%s'", chg.Path())
+				err = errors.Wrapf(err, "cannot process changed path '%s'", path)
 				log.Error(err)
 				continue
 			}
-			if isDir {
-				fiDir = append(fiDir, *filesFromDirs([]string{chg.Path()})...)
-			} else {
-				if !isDir {
-					if config.IsValidTrackFile(chg.Path()) {
-						fiDir = append(fiDir, newTrackInfo(chg.Path(), 0))
+			if exists {
+				// if it's a directory: Recursively expand it to the (supported)
+				// files that are contained in that directory. Otherwise, go
+				// forward with the single file. Excluded paths are treated as
+				// if they didn't exist
+				isDir, err := f.IsDir(path)
+				if err != nil {
+					err = errors.Wrapf(err, "cannot process changed path '%s'", path)
+					log.Error(err)
+					continue
+				}
+				if me.cnt.rules.isIgnored(path, isDir) {
+					log.Tracef("'%s' is excluded", path)
+				} else if isDir {
+					fiDir = append(fiDir, *me.cnt.filesFromDirs([]string{path})...)
+				} else {
+					if config.IsValidTrackFile(path) {
+						fiDir = append(fiDir, newTrackInfo(me.cnt, path, 0))
 					}
-					if config.IsValidPlaylistFile(chg.Path()) {
-						fiDir = append(fiDir, newPlaylistInfo(chg.Path(), 0))
+					if config.IsValidPlaylistFile(path) {
+						fiDir = append(fiDir, newPlaylistInfo(path, 0))
 					}
 				}
 			}
-		}
 
-		// collect all changed tracks that are contained in the content
-		fiCnt = append(fiCnt, *me.filesByPaths([]string{chg.Path()})...)
+			// collect all changed tracks that are contained in the content
+			fiCnt = append(fiCnt, *me.cnt.filesByPaths([]string{path})...)
+		}
 	}
 
 	// determine files to be deleted from or added to the content. fiCnt and
@@ -202,6 +323,14 @@ func (me *notifier) processChanges(ctx context.Context, cfg config.Cfg) {
 	fiDir.removeDuplicates()
 	fiDel, fiAdd := diff(fiCnt, fiDir)
 
+	me.apply(ctx, &fiDel, &fiAdd)
+
+	log.Trace("file system notifications processed")
+}
+
+// apply notifies the server that an update is required and - after the server
+// approved it - applies the changes to the content
+func (me *notifier) apply(ctx context.Context, fiDel, fiAdd *fileInfos) {
 	// create channel to notify server about finalized update
 	updated := make(chan uint32)
 	// close channel after update is done (this implicitly notifies the server
@@ -219,11 +348,9 @@ func (me *notifier) processChanges(ctx context.Context, cfg config.Cfg) {
 	// or added objects
 	var count uint32
 	var err error
-	if count, err = me.update(ctx, &fiDel, &fiAdd); err != nil {
+	if count, err = me.cnt.update(ctx, fiDel, fiAdd); err != nil {
 		me.errs <- err
 		return
 	}
 	updated <- count
-
-	log.Trace("file system notifications processed")
 }
@@ -2,7 +2,6 @@ package content
 
 import (
 	"bytes"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -64,7 +63,7 @@ type container interface {
 	numChildren() int
 	childByIndex(int) object
 	childByKey(uint64) (object, bool)
-	invalidateOrder()
+	reorderChild(object)
 	setComparison([]config.Comparison)
 	resetUpdCount()
 }
@@ -84,75 +83,61 @@ func (me objects) add(obj object) {
 //     attributes)
 //   - by an index in sort order
 //
-// After a child object was added or removed, the inOrder array is generated
-// from byID upon the access to it
+// The child objects are kept in sort order when they are added or removed (see
+// order.go)
 type refs struct {
 	byID    objects
 	byKey   map[uint64]object
-	inOrder []object
-	comps   []config.Comparison
+	inOrder order
 }
 
 // newRefs create a new refs instance
 func newRefs(comps []config.Comparison) (r refs) {
 	return refs{
-		byID:  make(objects),
-		byKey: make(map[uint64]object),
-		comps: comps,
+		byID:    make(objects),
+		byKey:   make(map[uint64]object),
+		inOrder: newOrder(comps),
 	}
 }
 
-// add adds a child object. The inOrder array is cleared.
+// add adds a child object
 func (me *refs) add(obj object) {
 	me.byID[obj.id()] = obj
 	me.byKey[obj.key()] = obj
-	if len(me.inOrder) > 0 {
-		me.inOrder = []object{}
-	}
+	me.inOrder.insert(obj)
 }
 
-// del removes a child object. The inOrder array is cleared.
+// del removes a child object
 func (me *refs) del(obj object) {
 	delete(me.byID, obj.id())
 	delete(me.byKey, obj.key())
-	me.inOrder = []object{}
+	me.inOrder.remove(obj)
 }
 
-// delAll removes all child object. The inOrder array is cleared.
+// delAll removes all child object
 func (me *refs) delAll() {
 	me.byID = make(objects)
 	me.byKey = make(map[uint64]object)
-	me.inOrder = []object{}
+	me.inOrder = newOrder(me.inOrder.comps)
 }
 
-// item returns child object number index according to the sort order. If the
-// inOrder array is empty, it is first generated
+// item returns child object number index according to the sort order
 func (me *refs) item(index int) object {
-	// if sorted array does not exist: create it
-	if len(me.inOrder) == 0 {
-		for _, obj := range me.byID {
-			me.inOrder = append(me.inOrder, obj)
-		}
-		sort.Slice(me.inOrder,
-			func(i, j int) bool {
-				for k := 0; k < len(me.comps); k++ {
-					if me.inOrder[i].sortField(k) == me.inOrder[j].sortField(k) {
-						continue
-					}
-					return me.comps[k](me.inOrder[i].sortField(k), me.inOrder[j].sortField(k))
-				}
-				return false
-			},
-		)
-	}
+	return me.inOrder.at(index)
+}
 
-	return me.inOrder[index]
+// reorder repositions the child object obj after its sort fields have changed
+func (me *refs) reorder(obj object) {
+	me.inOrder.reorder(obj)
 }
 
-// invalidateOrder clears the sorted array to trigger a new sort before the
-// next access)
-func (me *refs) invalidateOrder() {
-	me.inOrder = []object{}
+// setComparison sets the comparison functions that are needed to sort the
+// child objects and sorts them accordingly
+func (me *refs) setComparison(comps []config.Comparison) {
+	me.inOrder = newOrder(comps)
+	for _, obj := range me.byID {
+		me.inOrder.insert(obj)
+	}
 }
 
 // len returns the number of child objects
@@ -242,15 +227,16 @@ func (me *ctr) isContainer() bool {
 	return true
 }
 
-// invalidateOrder triggers a new sort of the children before the next access
-func (me *ctr) invalidateOrder() {
-	me.children.invalidateOrder()
+// reorderChild repositions the child object obj after its sort fields have
+// changed
+func (me *ctr) reorderChild(obj object) {
+	me.children.reorder(obj)
 }
 
 // setComparison set the comparison functions that are needed to sort the
 // children of the container
 func (me *ctr) setComparison(comps []config.Comparison) {
-	me.children.comps = comps
+	me.children.setComparison(comps)
 }
 
 // resetUpdCount recursively resets the ContainerUpdateIDValue
@@ -304,8 +290,9 @@ func (me folders) add(path string, folder folder) { me[path] = folder }
 // pictures maps a picture id (that's an uint64 FNV hash of the picture raw
 // data) to the picture raw data
 type pictures struct {
-	mu   sync.Mutex           // required for concurrent-safe write access
-	data map[uint64](*[]byte) // the actual map (id->raw data)
+	mu      sync.Mutex           // required for concurrent-safe write access
+	data    map[uint64](*[]byte) // the actual map (id->raw data)
+	workers chan struct{}        // limits the number of concurrent workers
 }
 
 // get picture raw data by id
@@ -316,22 +303,37 @@ func (me *pictures) get(id uint64) *[]byte {
 // add adds pictures to the pictures map. It take a picture from the tags of a
 // music file, resizes is and converts it to JPEG. It creates a picture id as
 // uint64 FNV hash of the raw data and adds it to the pictures map.
-// This function is designed to be executed concurrently.
+// The picture is processed by a worker in the background. If all workers are
+// busy, add blocks until one of them is available
 func (me *pictures) add(wg *sync.WaitGroup, pic *tag.Picture, picID *nonePicID) {
-	defer wg.Done()
-
 	if pic == nil {
 		return
 	}
 
+	me.workers <- struct{}{}
+	wg.Add(1)
+	go func() {
+		defer func() {
+			<-me.workers
+			wg.Done()
+		}()
+
+		var err error
+		if *picID, err = me.addData(pic.Data); err != nil {
+			log.Fatal(err)
+		}
+	}()
+}
+
+// addData resizes the picture raw data, converts it to JPEG and adds it to the
+// pictures map. The ID of the picture is returned
+func (me *pictures) addData(data []byte) (picID nonePicID, err error) {
 	//  resize picture
-	img, err := imaging.Decode(bytes.NewReader(pic.Data))
+	img, err := imaging.Decode(bytes.NewReader(data))
 	if err != nil {
 		err = errors.New("could not decode picture")
-		log.Fatal(err)
 		return
 	}
-	img = imaging.Resize(img, imgSize, 0, imaging.Box)
 	buf := new(bytes.Buffer)
 	if err = imaging.Encode(
 		buf,
@@ -339,12 +341,11 @@ func (me *pictures) add(wg *sync.WaitGroup, pic *tag.Picture, picID *nonePicID)
 		imaging.JPEG,
 	); err != nil {
 		err = errors.New("could not encode resized picture")
-		log.Fatal(err)
 		return
 	}
 	picture := buf.Bytes()
 
-	*picID = nonePicID{hash.HashUint64("%x", picture), true}
+	picID = nonePicID{hash.HashUint64("%x", picture), true}
 
 	me.mu.Lock()
 	_, exists := me.data[picID.id]
@@ -352,6 +353,8 @@ func (me *pictures) add(wg *sync.WaitGroup, pic *tag.Picture, picID *nonePicID)
 		me.data[picID.id] = &picture
 	}
 	me.mu.Unlock()
+
+	return
 }
 
 // nonePicID represents a picture ID incl. a "null" value
@@ -0,0 +1,184 @@
+package content
+
+import (
+	"math/rand"
+
+	"gitlab.com/mipimipi/muserv/src/internal/config"
+)
+
+// order keeps the child objects of a container sorted according to the
+// configured comparisons. It's implemented as treap (a binary search tree
+// whose nodes additionally form a heap wrt. random priorities, which keeps the
+// tree balanced) whose nodes know the size of their sub trees. Thus, objects
+// can be inserted and removed, and the object at a certain index can be
+// retrieved in O(log n).
+// Objects whose sort fields change (e.g. albums references if tracks are added
+// to the album) must be repositioned via reorder
+type order struct {
+	root  *orderNode
+	nodes map[ObjID]*orderNode
+	comps []config.Comparison
+}
+
+// orderNode is a node of an order
+type orderNode struct {
+	obj    object
+	prio   uint32
+	size   int // number of nodes of the sub tree with this node as root
+	parent *orderNode
+	left   *orderNode
+	right  *orderNode
+}
+
+// newOrder creates an empty order that sorts objects according to comps
+func newOrder(comps []config.Comparison) order {
+	return order{
+		nodes: make(map[ObjID]*orderNode),
+		comps: comps,
+	}
+}
+
+// sizeOf returns the size of the sub tree with root me
+func (me *orderNode) sizeOf() int {
+	if me == nil {
+		return 0
+	}
+	return me.size
+}
+
+// less returns true if a is sorted before b. Objects with the same sort fields
+// are sorted by their IDs
+func (me *order) less(a, b object) bool {
+	for k := 0; k < len(me.comps); k++ {
+		if a.sortField(k) == b.sortField(k) {
+			continue
+		}
+		return me.comps[k](a.sortField(k), b.sortField(k))
+	}
+	return a.id() < b.id()
+}
+
+// insert adds obj according to the sort order
+func (me *order) insert(obj object) {
+	me.remove(obj)
+
+	n := &orderNode{obj: obj, prio: rand.Uint32(), size: 1}
+	me.nodes[obj.id()] = n
+
+	// insert n as leaf
+	var parent *orderNode
+	for cur := me.root; cur != nil; {
+		cur.size++
+		parent = cur
+		if me.less(obj, cur.obj) {
+			cur = cur.left
+		} else {
+			cur = cur.right
+		}
+	}
+	n.parent = parent
+	switch {
+	case parent == nil:
+		me.root = n
+	case me.less(obj, parent.obj):
+		parent.left = n
+	default:
+		parent.right = n
+	}
+
+	// restore the heap property
+	for n.parent != nil && n.prio > n.parent.prio {
+		me.rotateUp(n)
+	}
+}
+
+// remove removes obj
+func (me *order) remove(obj object) {
+	n, exists := me.nodes[obj.id()]
+	if !exists {
+		return
+	}
+	delete(me.nodes, obj.id())
+
+	// rotate n down until it's a leaf
+	for n.left != nil || n.right != nil {
+		if n.right == nil || (n.left != nil && n.left.prio > n.right.prio) {
+			me.rotateUp(n.left)
+		} else {
+			me.rotateUp(n.right)
+		}
+	}
+
+	// remove leaf and adjust the sizes of its ancestors
+	switch {
+	case n.parent == nil:
+		me.root = nil
+	case n.parent.left == n:
+		n.parent.left = nil
+	default:
+		n.parent.right = nil
+	}
+	for p := n.parent; p != nil; p = p.parent {
+		p.size--
+	}
+}
+
+// reorder repositions obj after its sort fields have changed
+func (me *order) reorder(obj object) {
+	if _, exists := me.nodes[obj.id()]; exists {
+		me.insert(obj)
+	}
+}
+
+// at returns the object at position index
+func (me *order) at(index int) object {
+	n := me.root
+	for n != nil {
+		left := n.left.sizeOf()
+		switch {
+		case index < left:
+			n = n.left
+		case index == left:
+			return n.obj
+		default:
+			index -= left + 1
+			n = n.right
+		}
+	}
+	return nil
+}
+
+// len returns the number of objects
+func (me *order) len() int { return me.root.sizeOf() }
+
+// rotateUp rotates n with its parent, so that n takes the place of its parent
+func (me *order) rotateUp(n *orderNode) {
+	p := n.parent
+	if p.left == n {
+		p.left = n.right
+		if n.right != nil {
+			n.right.parent = p
+		}
+		n.right = p
+	} else {
+		p.right = n.left
+		if n.left != nil {
+			n.left.parent = p
+		}
+		n.left = p
+	}
+
+	n.parent = p.parent
+	switch {
+	case p.parent == nil:
+		me.root = n
+	case p.parent.left == p:
+		p.parent.left = n
+	default:
+		p.parent.right = n
+	}
+	p.parent = n
+
+	p.size = p.left.sizeOf() + p.right.sizeOf() + 1
+	n.size = n.left.sizeOf() + n.right.sizeOf() + 1
+}
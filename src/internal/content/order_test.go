@@ -0,0 +1,206 @@
+package content
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+
+	"gitlab.com/mipimipi/muserv/src/internal/config"
+)
+
+// sortObj is an object with the ID objID and the sort fields fields. Only the
+// methods that are required by order are implemented
+type sortObj struct {
+	object
+	objID  ObjID
+	fields []string
+}
+
+func (me *sortObj) id() ObjID              { return me.objID }
+func (me *sortObj) sortField(k int) string { return me.fields[k] }
+
+// ascending and descending are the comparisons that are used in the tests
+func ascending(a, b string) bool  { return a < b }
+func descending(a, b string) bool { return a > b }
+
+// ids returns the IDs of the objects of o in their order
+func ids(o *order) []ObjID {
+	var ids []ObjID
+	for i := 0; i < o.len(); i++ {
+		ids = append(ids, o.at(i).id())
+	}
+	return ids
+}
+
+// checkOrderNode checks the sizes, parent pointers, search tree and heap
+// properties of the sub tree with root n
+func checkOrderNode(t *testing.T, o *order, n *orderNode) {
+	if n == nil {
+		return
+	}
+	if n.size != n.left.sizeOf()+n.right.sizeOf()+1 {
+		t.Errorf("node %d: size = %d, want %d", n.obj.id(), n.size, n.left.sizeOf()+n.right.sizeOf()+1)
+	}
+	for _, child := range []*orderNode{n.left, n.right} {
+		if child == nil {
+			continue
+		}
+		if child.parent != n {
+			t.Errorf("node %d: wrong parent", child.obj.id())
+		}
+		if child.prio > n.prio {
+			t.Errorf("node %d: priority is greater than the priority of its parent", child.obj.id())
+		}
+	}
+	if n.left != nil && !o.less(n.left.obj, n.obj) {
+		t.Errorf("node %d: left child is not less", n.obj.id())
+	}
+	if n.right != nil && !o.less(n.obj, n.right.obj) {
+		t.Errorf("node %d: right child is not greater", n.obj.id())
+	}
+	checkOrderNode(t, o, n.left)
+	checkOrderNode(t, o, n.right)
+}
+
+func TestOrder(t *testing.T) {
+	a := &sortObj{objID: 1, fields: []string{"b", "x"}}
+	b := &sortObj{objID: 2, fields: []string{"a", "x"}}
+	c := &sortObj{objID: 3, fields: []string{"a", "y"}}
+	d := &sortObj{objID: 4, fields: []string{"a", "y"}}
+
+	tests := []struct {
+		name  string
+		comps []config.Comparison
+		ops   func(o *order)
+		want  []ObjID
+	}{
+		{
+			name:  "empty",
+			comps: []config.Comparison{ascending},
+			ops:   func(o *order) {},
+		},
+		{
+			name:  "ascending",
+			comps: []config.Comparison{ascending},
+			ops:   func(o *order) { o.insert(a); o.insert(b) },
+			want:  []ObjID{2, 1},
+		},
+		{
+			name:  "second sort field, ties are broken by ID",
+			comps: []config.Comparison{ascending, descending},
+			ops:   func(o *order) { o.insert(d); o.insert(a); o.insert(c); o.insert(b) },
+			want:  []ObjID{3, 4, 2, 1},
+		},
+		{
+			name:  "insert twice",
+			comps: []config.Comparison{ascending},
+			ops:   func(o *order) { o.insert(a); o.insert(b); o.insert(a) },
+			want:  []ObjID{2, 1},
+		},
+		{
+			name:  "remove",
+			comps: []config.Comparison{ascending, ascending},
+			ops:   func(o *order) { o.insert(a); o.insert(b); o.insert(c); o.remove(b); o.remove(b) },
+			want:  []ObjID{3, 1},
+		},
+		{
+			name:  "remove unknown object",
+			comps: []config.Comparison{ascending},
+			ops:   func(o *order) { o.insert(a); o.remove(b) },
+			want:  []ObjID{1},
+		},
+		{
+			name:  "reorder unknown object",
+			comps: []config.Comparison{ascending},
+			ops:   func(o *order) { o.insert(a); o.reorder(b) },
+			want:  []ObjID{1},
+		},
+	}
+	for _, tt := range tests {
+		o := newOrder(tt.comps)
+		tt.ops(&o)
+		if got := ids(&o); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: order = %v, want %v", tt.name, got, tt.want)
+		}
+		if o.at(o.len()) != nil || o.at(-1) != nil {
+			t.Errorf("%s: at() returns an object for an index out of range", tt.name)
+		}
+		checkOrderNode(t, &o, o.root)
+	}
+}
+
+func TestOrderReorder(t *testing.T) {
+	a := &sortObj{objID: 1, fields: []string{"a"}}
+	b := &sortObj{objID: 2, fields: []string{"b"}}
+	c := &sortObj{objID: 3, fields: []string{"c"}}
+
+	o := newOrder([]config.Comparison{ascending})
+	o.insert(a)
+	o.insert(b)
+	o.insert(c)
+
+	a.fields[0] = "d"
+	o.reorder(a)
+	if got, want := ids(&o), []ObjID{2, 3, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+	checkOrderNode(t, &o, o.root)
+}
+
+// TestOrderRandom inserts, removes and reorders objects randomly and compares
+// the order with the result of sort.Slice
+func TestOrderRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	o := newOrder([]config.Comparison{ascending, descending})
+
+	objs := make([]*sortObj, 200)
+	for i := range objs {
+		objs[i] = &sortObj{objID: ObjID(i)}
+	}
+	randomFields := func() []string {
+		// few different values to get ties
+		return []string{string(rune('a' + rnd.Intn(5))), string(rune('a' + rnd.Intn(5)))}
+	}
+	contained := make(map[ObjID]bool)
+
+	for step := 0; step < 5000; step++ {
+		obj := objs[rnd.Intn(len(objs))]
+		switch rnd.Intn(3) {
+		case 0:
+			obj.fields = randomFields()
+			o.insert(obj)
+			contained[obj.objID] = true
+		case 1:
+			o.remove(obj)
+			delete(contained, obj.objID)
+		default:
+			if contained[obj.objID] {
+				obj.fields = randomFields()
+				o.reorder(obj)
+			}
+		}
+
+		if step%100 != 0 {
+			continue
+		}
+		var want []ObjID
+		for id := range contained {
+			want = append(want, id)
+		}
+		sort.Slice(want, func(i, j int) bool {
+			a, b := objs[want[i]].fields, objs[want[j]].fields
+			if a[0] != b[0] {
+				return a[0] < b[0]
+			}
+			if a[1] != b[1] {
+				return a[1] > b[1]
+			}
+			return want[i] < want[j]
+		})
+		if got := ids(&o); !reflect.DeepEqual(got, want) {
+			t.Fatalf("step %d: order = %v, want %v", step, got, want)
+		}
+		checkOrderNode(t, &o, o.root)
+	}
+}
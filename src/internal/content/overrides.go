@@ -0,0 +1,284 @@
+package content
+
+// this file contains the logic for tag overrides. They allow to correct tags
+// without modifying the track files (e.g. if the music directories are read
+// only). Tag overrides are read from files named .muserv-tags.json in the music
+// directories, which apply to the tracks in that directory and its sub
+// directories, and from the central file muserv-tags.json in the cache
+// directory. Each file maps paths or path patterns (relative to the directory
+// of the file, or absolute for the central file) to the tag values that
+// replace the values from the track files
+
+import (
+	"encoding/json"
+	"os"
+	p "path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"gitlab.com/go-utilities/filepath"
+	"gitlab.com/mipimipi/muserv/src/internal/config"
+)
+
+// names of the files that contain tag overrides
+const (
+	dirOverridesFile     = ".muserv-tags.json"
+	centralOverridesFile = "muserv-tags.json"
+)
+
+// tagOverride contains the tag values that replace the values from the track
+// files. Only values that are set are replaced
+type tagOverride struct {
+	AlbumArtist *string `json:"album_artist"`
+	Genre       *string `json:"genre"`
+	Year        *int    `json:"year"`
+	Compilation *bool   `json:"compilation"`
+	Title       *string `json:"title"`
+}
+
+// merge sets the values that are set in o
+func (me *tagOverride) merge(o tagOverride) {
+	if o.AlbumArtist != nil {
+		me.AlbumArtist = o.AlbumArtist
+	}
+	if o.Genre != nil {
+		me.Genre = o.Genre
+	}
+	if o.Year != nil {
+		me.Year = o.Year
+	}
+	if o.Compilation != nil {
+		me.Compilation = o.Compilation
+	}
+	if o.Title != nil {
+		me.Title = o.Title
+	}
+}
+
+// overridesFile contains the content of a tag overrides file
+type overridesFile struct {
+	lastChange int64                  // UNIX time of last change of the file
+	patterns   []string               // paths and path patterns (sorted ascending by specificity)
+	entries    map[string]tagOverride // overrides per path or path pattern
+}
+
+// readOverridesFile reads the tag overrides file path. If the file cannot be
+// parsed, the error is logged and an empty file is returned
+func readOverridesFile(path string, lastChange int64) *overridesFile {
+	of := overridesFile{lastChange: lastChange}
+
+	b, err := os.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(b, &of.entries)
+	}
+	if err != nil {
+		log.Error(errors.Wrapf(err, "cannot read tag overrides from '%s'", path))
+		return &of
+	}
+
+	// more specific entries take precedence over less specific ones. Thus,
+	// they are applied later: paths after patterns, and longer patterns after
+	// shorter ones
+	for pattern := range of.entries {
+		of.patterns = append(of.patterns, pattern)
+	}
+	isPattern := func(s string) bool { return strings.ContainsAny(s, `*?[\`) }
+	sort.Slice(of.patterns, func(i, j int) bool {
+		if isPattern(of.patterns[i]) != isPattern(of.patterns[j]) {
+			return isPattern(of.patterns[i])
+		}
+		if len(of.patterns[i]) != len(of.patterns[j]) {
+			return len(of.patterns[i]) < len(of.patterns[j])
+		}
+		return of.patterns[i] < of.patterns[j]
+	})
+
+	return &of
+}
+
+// match merges the overrides of all entries that match the path rel into o.
+// matched is true if at least one entry matches
+func (me *overridesFile) match(rel string, o *tagOverride) (matched bool) {
+	for _, pattern := range me.patterns {
+		if matchesOverride(pattern, rel) {
+			o.merge(me.entries[pattern])
+			matched = true
+		}
+	}
+	return
+}
+
+// changedPatterns returns the paths and path patterns whose entries differ
+// between the overrides files before and after (i.e. that have been added, removed
+// or changed). before and after can be nil
+func changedPatterns(before, after *overridesFile) (patterns []string) {
+	var oldEntries, newEntries map[string]tagOverride
+	if before != nil {
+		oldEntries = before.entries
+	}
+	if after != nil {
+		newEntries = after.entries
+	}
+	for pattern, o := range oldEntries {
+		if n, exists := newEntries[pattern]; !exists || !reflect.DeepEqual(o, n) {
+			patterns = append(patterns, pattern)
+		}
+	}
+	for pattern := range newEntries {
+		if _, exists := oldEntries[pattern]; !exists {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return
+}
+
+// matchesOverride returns true if the path rel matches the path or path
+// pattern of an overrides entry. Patterns without "/" match the file name of
+// tracks in all sub directories, the other patterns match the complete path
+func matchesOverride(pattern, rel string) bool {
+	if !strings.Contains(pattern, "/") {
+		rel = p.Base(rel)
+	}
+	ok, _ := p.Match(pattern, rel)
+	return ok
+}
+
+// tagOverrides provides the tag overrides for track files. The overrides files
+// are cached and only read again if they have been changed
+type tagOverrides struct {
+	mu        sync.Mutex
+	central   string                    // path of the central overrides file
+	musicDirs []string                  // music directories
+	files     map[string]*overridesFile // cache of overrides files (nil if a file doesn't exist)
+	replaced  map[string]*overridesFile // versions of changed files until the change is processed
+}
+
+// newTagOverrides creates a new tagOverrides instance from the configuration
+func newTagOverrides(cfg *config.Cfg) *tagOverrides {
+	return &tagOverrides{
+		central:   p.Join(cfg.CacheDir, centralOverridesFile),
+		musicDirs: cfg.Cnt.MusicDirs,
+		files:     make(map[string]*overridesFile),
+		replaced:  make(map[string]*overridesFile),
+	}
+}
+
+// file returns the overrides file path. nil is returned if it doesn't exist.
+// If the file has been changed, created or removed since it was read the last
+// time, the cached version is kept as replaced version until the change has
+// been processed (see affectedPaths)
+func (me *tagOverrides) file(path string) *overridesFile {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	cached, known := me.files[path]
+	update := func(of *overridesFile) {
+		if _, exists := me.replaced[path]; known && !exists {
+			me.replaced[path] = cached
+		}
+		me.files[path] = of
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		if !known || cached != nil {
+			update(nil)
+		}
+		return nil
+	}
+	lastChange := int64(info.Sys().(*syscall.Stat_t).Ctim.Sec)
+
+	if cached == nil || cached.lastChange != lastChange {
+		update(readOverridesFile(path, lastChange))
+	}
+	return me.files[path]
+}
+
+// get returns the tag overrides for the track file path and the time of the
+// last change of the overrides files that contain entries for path (0 if there
+// are no such files). First, the entries of the central file are applied, then
+// the entries of the files in the music directory from the top-level directory
+// down to the directory of the track
+func (me *tagOverrides) get(path string) (o tagOverride, lastChange int64) {
+	if me == nil {
+		return
+	}
+
+	apply := func(file, rel string) {
+		if of := me.file(file); of != nil && of.match(rel, &o) {
+			lastChange = max(lastChange, of.lastChange)
+		}
+	}
+
+	apply(me.central, path)
+
+	// determine the directories from the music dir down to the directory of
+	// the track
+	root := p.Dir(path)
+	for _, dir := range me.musicDirs {
+		if isSub, _ := filepath.IsSub(dir, path); isSub {
+			root = dir
+			break
+		}
+	}
+	for _, dir := range dirsBelow(root, p.Dir(path)) {
+		apply(p.Join(dir, dirOverridesFile), strings.TrimPrefix(path, dir+"/"))
+	}
+
+	return
+}
+
+// isOverridesFile returns true if path is a tag overrides file
+func (me *tagOverrides) isOverridesFile(path string) bool {
+	if me == nil {
+		return false
+	}
+	return p.Base(path) == dirOverridesFile || path == me.central
+}
+
+// affectedPaths returns the paths of the tracks that are affected by a change
+// of the overrides file path, i.e. the tracks that are matched by entries that
+// have been added, removed or changed. below calls a function for all files of
+// the content below a directory
+func (me *tagOverrides) affectedPaths(path string, below func(string, func(string, infoKind))) (paths []string) {
+	after := me.file(path)
+
+	me.mu.Lock()
+	before, replaced := me.replaced[path]
+	delete(me.replaced, path)
+	me.mu.Unlock()
+
+	if !replaced {
+		return
+	}
+	patterns := changedPatterns(before, after)
+	if len(patterns) == 0 {
+		return
+	}
+
+	// paths in the central file are absolute, in the other files they are
+	// relative to the directory of the file
+	dirs, prefix := []string{p.Dir(path)}, p.Dir(path)+"/"
+	if path == me.central {
+		dirs, prefix = me.musicDirs, ""
+	}
+	for _, dir := range dirs {
+		below(dir, func(trackPath string, kind infoKind) {
+			if kind != infoTrack {
+				return
+			}
+			rel := strings.TrimPrefix(trackPath, prefix)
+			for _, pattern := range patterns {
+				if matchesOverride(pattern, rel) {
+					paths = append(paths, trackPath)
+					return
+				}
+			}
+		})
+	}
+	return
+}
@@ -0,0 +1,136 @@
+package content
+
+import (
+	p "path"
+	"strings"
+)
+
+// pathIndex is a trie of the paths of the tracks and playlists of the content.
+// It allows to determine the files below a directory without iterating over
+// all tracks and playlists. Each node corresponds to one element of a path
+type pathIndex struct {
+	root *pathNode
+}
+
+// pathNode is a node of the path index. kind is infoNone for directories
+type pathNode struct {
+	children map[string]*pathNode
+	kind     infoKind
+}
+
+// newPathIndex creates an empty path index
+func newPathIndex() *pathIndex {
+	return &pathIndex{root: &pathNode{}}
+}
+
+// add adds the file path of kind kind to the index
+func (me *pathIndex) add(path string, kind infoKind) {
+	node := me.root
+	for _, elem := range strings.Split(path, "/") {
+		child, exists := node.children[elem]
+		if !exists {
+			if node.children == nil {
+				node.children = make(map[string]*pathNode)
+			}
+			child = &pathNode{}
+			node.children[elem] = child
+		}
+		node = child
+	}
+	node.kind = kind
+}
+
+// del removes the file path from the index. Nodes that are no longer required
+// are removed as well
+func (me *pathIndex) del(path string) {
+	elems := strings.Split(path, "/")
+	nodes := make([]*pathNode, 0, len(elems)+1)
+	node := me.root
+	for _, elem := range elems {
+		nodes = append(nodes, node)
+		child, exists := node.children[elem]
+		if !exists {
+			return
+		}
+		node = child
+	}
+	node.kind = infoNone
+
+	// remove the nodes from the leaf upwards as long as they are empty
+	for i := len(elems) - 1; i >= 0 && node.kind == infoNone && len(node.children) == 0; i-- {
+		delete(nodes[i].children, elems[i])
+		node = nodes[i]
+	}
+}
+
+// below calls f for each file whose path is path or starts with path
+func (me *pathIndex) below(path string, f func(path string, kind infoKind)) {
+	// the trailing separator of the root directory is removed, since the
+	// paths of the children are assembled by adding a separator
+	path = strings.TrimSuffix(p.Clean(path), "/")
+	if node := me.node(path); node != nil {
+		node.walk(path, f)
+	}
+}
+
+// hasAny returns true if there's at least one file whose path is path or
+// starts with path. In contrast to below, it stops at the first file
+func (me *pathIndex) hasAny(path string) bool {
+	return me.node(strings.TrimSuffix(p.Clean(path), "/")).hasFile()
+}
+
+// filesIn calls f for each file that is directly contained in directory dir
+// (i.e. not in one of its sub directories)
+func (me *pathIndex) filesIn(dir string, f func(path string, kind infoKind)) {
+	dir = strings.TrimSuffix(p.Clean(dir), "/")
+	node := me.node(dir)
+	if node == nil {
+		return
+	}
+	for elem, child := range node.children {
+		if child.kind != infoNone {
+			f(dir+"/"+elem, child.kind)
+		}
+	}
+}
+
+// node returns the node of path or nil if path is not contained in the index
+func (me *pathIndex) node(path string) *pathNode {
+	node := me.root
+	for _, elem := range strings.Split(path, "/") {
+		child, exists := node.children[elem]
+		if !exists {
+			return nil
+		}
+		node = child
+	}
+	return node
+}
+
+// walk calls f for the node with path path and all nodes below it that
+// represent files
+func (me *pathNode) walk(path string, f func(path string, kind infoKind)) {
+	if me.kind != infoNone {
+		f(path, me.kind)
+	}
+	for elem, child := range me.children {
+		child.walk(path+"/"+elem, f)
+	}
+}
+
+// hasFile returns true if the node or one of the nodes below it represents a
+// file
+func (me *pathNode) hasFile() bool {
+	if me == nil {
+		return false
+	}
+	if me.kind != infoNone {
+		return true
+	}
+	for _, child := range me.children {
+		if child.hasFile() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,173 @@
+package content
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// testPathIndex creates a path index that contains paths as tracks
+func testPathIndex(paths ...string) *pathIndex {
+	idx := newPathIndex()
+	for _, path := range paths {
+		idx.add(path, infoTrack)
+	}
+	return idx
+}
+
+// collect returns the sorted paths that fn passes to its callback
+func collect(fn func(f func(path string, kind infoKind))) []string {
+	var paths []string
+	fn(func(path string, _ infoKind) { paths = append(paths, path) })
+	sort.Strings(paths)
+	return paths
+}
+
+func TestPathIndexBelow(t *testing.T) {
+	idx := testPathIndex("/m/a/1.flac", "/m/a/2.flac", "/m/a/b/3.flac", "/m/ab/4.flac", "/n/5.flac")
+	idx.add("/m/a/list.m3u", infoPlaylist)
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"/", []string{"/m/a/1.flac", "/m/a/2.flac", "/m/a/b/3.flac", "/m/a/list.m3u", "/m/ab/4.flac", "/n/5.flac"}},
+		{"/m/a", []string{"/m/a/1.flac", "/m/a/2.flac", "/m/a/b/3.flac", "/m/a/list.m3u"}},
+		{"/m/a/", []string{"/m/a/1.flac", "/m/a/2.flac", "/m/a/b/3.flac", "/m/a/list.m3u"}},
+		{"/m/a/b/../b", []string{"/m/a/b/3.flac"}},
+		{"/m/a/1.flac", []string{"/m/a/1.flac"}},
+		// path elements are not matched as prefixes of names
+		{"/m/a/1", nil},
+		{"/x", nil},
+	}
+	for _, tt := range tests {
+		if got := collect(func(f func(string, infoKind)) { idx.below(tt.path, f) }); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("below(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+
+	var kinds []infoKind
+	idx.below("/m/a/list.m3u", func(_ string, kind infoKind) { kinds = append(kinds, kind) })
+	if !reflect.DeepEqual(kinds, []infoKind{infoPlaylist}) {
+		t.Errorf("below(/m/a/list.m3u): kinds = %v, want [%v]", kinds, infoPlaylist)
+	}
+}
+
+func TestPathIndexDel(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+		del   string
+		want  []string
+		empty []string // directories that must no longer be contained
+		keep  []string // directories that must still be contained
+	}{
+		{
+			name:  "sibling remains",
+			paths: []string{"/m/a/1.flac", "/m/a/2.flac"},
+			del:   "/m/a/1.flac",
+			want:  []string{"/m/a/2.flac"},
+			keep:  []string{"/m/a", "/m"},
+		},
+		{
+			name:  "empty directories are removed",
+			paths: []string{"/m/a/b/1.flac", "/m/c/2.flac"},
+			del:   "/m/a/b/1.flac",
+			want:  []string{"/m/c/2.flac"},
+			empty: []string{"/m/a/b", "/m/a"},
+			keep:  []string{"/m"},
+		},
+		{
+			name:  "file in parent directory remains",
+			paths: []string{"/m/a/b/1.flac", "/m/a/2.flac"},
+			del:   "/m/a/b/1.flac",
+			want:  []string{"/m/a/2.flac"},
+			empty: []string{"/m/a/b"},
+			keep:  []string{"/m/a"},
+		},
+		{
+			name:  "files below a deleted path remain",
+			paths: []string{"/m/a", "/m/a/1.flac"},
+			del:   "/m/a",
+			want:  []string{"/m/a/1.flac"},
+			keep:  []string{"/m/a"},
+		},
+		{
+			name:  "unknown path",
+			paths: []string{"/m/a/1.flac"},
+			del:   "/m/a/x.flac",
+			want:  []string{"/m/a/1.flac"},
+		},
+		{
+			name:  "last file",
+			paths: []string{"/m/a/1.flac"},
+			del:   "/m/a/1.flac",
+			empty: []string{"/m/a", "/m", ""},
+		},
+	}
+	for _, tt := range tests {
+		idx := testPathIndex(tt.paths...)
+		idx.del(tt.del)
+		if got := collect(func(f func(string, infoKind)) { idx.below("/", f) }); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: paths = %q, want %q", tt.name, got, tt.want)
+		}
+		for _, dir := range tt.empty {
+			if idx.node(dir) != nil {
+				t.Errorf("%s: node %q still exists", tt.name, dir)
+			}
+		}
+		for _, dir := range tt.keep {
+			if idx.node(dir) == nil {
+				t.Errorf("%s: node %q was removed", tt.name, dir)
+			}
+		}
+	}
+}
+
+func TestPathIndexHasAny(t *testing.T) {
+	idx := testPathIndex("/m/a/b/1.flac")
+	idx.add("/m/c/2.flac", infoTrack)
+	idx.del("/m/c/2.flac")
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/", true},
+		{"/m", true},
+		{"/m/a/", true},
+		{"/m/a/b/1.flac", true},
+		{"/m/a/b/1", false},
+		{"/m/c", false},
+		{"/x", false},
+	}
+	for _, tt := range tests {
+		if got := idx.hasAny(tt.path); got != tt.want {
+			t.Errorf("hasAny(%q) = %t, want %t", tt.path, got, tt.want)
+		}
+	}
+	if newPathIndex().hasAny("/") {
+		t.Error("hasAny(/) = true for empty index, want false")
+	}
+}
+
+func TestPathIndexFilesIn(t *testing.T) {
+	idx := testPathIndex("/m/a/1.flac", "/m/a/2.flac", "/m/a/b/3.flac", "/4.flac")
+
+	tests := []struct {
+		dir  string
+		want []string
+	}{
+		{"/m/a", []string{"/m/a/1.flac", "/m/a/2.flac"}},
+		{"/m/a/", []string{"/m/a/1.flac", "/m/a/2.flac"}},
+		{"/m/a/b", []string{"/m/a/b/3.flac"}},
+		{"/m", nil},
+		{"/", []string{"/4.flac"}},
+		{"/x", nil},
+	}
+	for _, tt := range tests {
+		if got := collect(func(f func(string, infoKind)) { idx.filesIn(tt.dir, f) }); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("filesIn(%q) = %q, want %q", tt.dir, got, tt.want)
+		}
+	}
+}
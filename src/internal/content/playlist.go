@@ -30,6 +30,7 @@ func newPlaylist(cnt *Content, wg *sync.WaitGroup, count *uint32, pli playlistIn
 	pl.marshalFunc = newPlaylistMarshalFunc(pl)
 
 	cnt.playlists[pli.path()] = pl
+	cnt.paths.add(pli.path(), infoPlaylist)
 	cnt.objects.add(pl)
 
 	var f *os.File
@@ -135,7 +136,7 @@ func trackFromPlaylistItem(cnt *Content, wg *sync.WaitGroup, count *uint32, path
 		// exist
 		t, exists = cnt.tracks[path]
 		if !exists {
-			if t, err = newTrack(cnt, wg, count, newTrackInfo(path, 0)); err != nil {
+			if t, err = newTrack(cnt, wg, count, newTrackInfo(cnt, path, 0)); err != nil {
 				err = errors.Wrapf(err, "cannot create a track for playlist item '%s': ignore it", path)
 				log.Error(err)
 				return
@@ -0,0 +1,73 @@
+package content
+
+// this file contains the logic to limit the rate in which track files are read
+// (see configuration option io_rate_limit). That's relevant for music
+// directories on network file systems, since reading tags and measuring
+// loudness during a full scan can otherwise saturate the network or the NAS
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"gitlab.com/mipimipi/muserv/src/internal/config"
+)
+
+// rateLimiter limits the rate in which data is read. It's shared by all
+// readers
+type rateLimiter struct {
+	mu   sync.Mutex
+	rate float64   // bytes per second
+	next time.Time // time from which on data can be read again
+}
+
+// newRateLimiter creates a new rateLimiter instance from the configuration.
+// nil is returned if the rate shall not be limited
+func newRateLimiter(cfg *config.Cfg) *rateLimiter {
+	if cfg.Cnt.IORateLimit <= 0 {
+		return nil
+	}
+	return &rateLimiter{rate: cfg.Cnt.IORateLimit * 1024 * 1024}
+}
+
+// wait accounts for n bytes that have been read and blocks until reading them
+// complies with the rate
+func (me *rateLimiter) wait(n int) {
+	if me == nil || n <= 0 {
+		return
+	}
+
+	me.mu.Lock()
+	now := time.Now()
+	if me.next.Before(now) {
+		me.next = now
+	}
+	me.next = me.next.Add(time.Duration(float64(n) / me.rate * float64(time.Second)))
+	delay := me.next.Sub(now)
+	me.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// limitedFile is a file whose reads are limited by a rate limiter
+type limitedFile struct {
+	*os.File
+	limiter *rateLimiter
+}
+
+// openLimited opens the file path for reading with the rate that limiter
+// allows. If limiter is nil, the rate is not limited
+func openLimited(path string, limiter *rateLimiter) (*limitedFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &limitedFile{f, limiter}, nil
+}
+
+// Read implements the io.Reader interface
+func (me *limitedFile) Read(b []byte) (n int, err error) {
+	n, err = me.File.Read(b)
+	me.limiter.wait(n)
+	return
+}
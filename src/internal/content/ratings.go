@@ -0,0 +1,106 @@
+package content
+
+// this file contains the logic to read track ratings. Different players store
+// ratings differently: Windows Media Player, MusicBee and foobar2000 (for
+// ID3v2) use the popularimeter frame (POPM) with a value between 0 and 255,
+// Amarok, Clementine and others use FMPS_RATING with a value between 0.0 and
+// 1.0, and RATING (Vorbis comments) contains either the number of stars (1-5)
+// or a percentage (0-100). All ratings are normalized to a scale from 0 to 5,
+// where 0 means "not rated"
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/dhowden/tag"
+)
+
+// maxRating is the maximum rating (i.e. five stars)
+const maxRating = 5.0
+
+// readRating reads the rating of a track from the tags m and normalizes it to
+// the scale 0-5. 0 is returned if the track is not rated
+func readRating(m tag.Metadata) float64 {
+	if r := popmRating(m); r > 0 {
+		return r
+	}
+	if s := rawTag(m, "FMPS_RATING"); len(s) > 0 {
+		if v, err := strconv.ParseFloat(s, 64); err == nil && v > 0 && v <= 1 {
+			return roundRating(v * maxRating)
+		}
+	}
+	if s := rawTag(m, "RATING"); len(s) > 0 {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v <= 0 {
+			return 0
+		}
+		// values above 5 are percentages
+		if v > maxRating {
+			v = math.Min(v, 100) / 20
+		}
+		return roundRating(v)
+	}
+	return 0
+}
+
+// popmRating returns the rating from the popularimeter frames (POPM for
+// ID3v2.3/2.4, POP for ID3v2.2). A frame consists of an email address (that
+// identifies the player that wrote the frame), the rating as one byte and a
+// play counter. If there are multiple frames (from different players), the
+// highest rating is taken
+func popmRating(m tag.Metadata) (r float64) {
+	for k, v := range m.Raw() {
+		// multiple frames are stored as POPM, POPM_1, ...
+		if !strings.HasPrefix(k, "POP") {
+			continue
+		}
+		b, ok := v.([]byte)
+		if !ok {
+			continue
+		}
+		// skip email address
+		i := strings.IndexByte(string(b), 0)
+		if i < 0 || i+1 >= len(b) {
+			continue
+		}
+		r = math.Max(r, popmStars(b[i+1]))
+	}
+	return
+}
+
+// popmStars converts a POPM rating (1-255) into stars. The ranges are the ones
+// that Windows Media Player uses. The values written by other players (e.g.
+// 1, 64, 128, 196 and 255 by MusicBee and foobar2000) are inside these ranges
+func popmStars(b byte) float64 {
+	switch {
+	case b == 0:
+		return 0
+	case b < 64:
+		return 1
+	case b < 128:
+		return 2
+	case b < 196:
+		return 3
+	case b < 255:
+		return 4
+	}
+	return 5
+}
+
+// roundRating rounds a rating to half stars
+func roundRating(r float64) float64 {
+	return math.Min(math.Round(r*2)/2, maxRating)
+}
+
+// ratingString returns the rating in the format that's used for upnp:rating
+// (e.g. "4" or "3.5"). Average ratings of albums are rounded to one decimal
+func ratingString(r float64) string {
+	return strconv.FormatFloat(math.Round(r*10)/10, 'f', -1, 64)
+}
+
+// ratingSortString returns the rating as string that can be used for sorting
+func ratingSortString(r float64) string {
+	return fmt.Sprintf("%04.2f", r)
+}
@@ -0,0 +1,422 @@
+package content
+
+// this file contains the logic to read tags with multiple values natively
+// from track files. Package tag only provides one string per tag: For Vorbis
+// comments only the last of multiple fields with the same name is kept, for
+// ID3v2.4 null-separated values are concatenated
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/dhowden/tag"
+)
+
+// maximum size of a metadata block or packet that is read natively
+const maxRawTagSize = 16 << 20
+
+// errRawTags is returned if the tags of a track file cannot be read natively
+var errRawTags = errors.New("unsupported or corrupt tag structure")
+
+// rawTags contains the values of the tags of a track file as they are stored
+// in the file. Each tag can have multiple values. The map is keyed by the
+// lower case tag names. For ID3v2, these are the frame IDs (e.g. "tpe1"). For
+// user defined text frames, the key is "txxx:" followed by the description of
+// the frame
+type rawTags map[string][]string
+
+// get returns the values of the first tag from names that exists
+func (me rawTags) get(names ...string) []string {
+	for _, name := range names {
+		if values, exists := me[name]; exists && len(values) > 0 {
+			return values
+		}
+	}
+	return nil
+}
+
+// readRawTags reads the tags of the track file r natively. m is the metadata
+// that package tag has read from r already. It's used to determine the format
+// of the tags. For MP4, the values are taken from m. If the tags cannot be
+// read natively, nil is returned
+func readRawTags(r io.ReadSeeker, m tag.Metadata) (raw rawTags) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil
+	}
+
+	var err error
+	switch m.Format() {
+	case tag.VORBIS:
+		switch m.FileType() {
+		case tag.FLAC:
+			raw, err = readFLACRawTags(r)
+		case tag.OGG:
+			raw, err = readOGGRawTags(r)
+		}
+	case tag.ID3v2_3, tag.ID3v2_4:
+		raw, err = readID3v2RawTags(r)
+	case tag.MP4:
+		raw = mp4RawTags(m)
+	}
+	if err != nil {
+		log.Tracef("cannot read tags natively: %v", err)
+		return nil
+	}
+
+	return
+}
+
+// readFLACRawTags reads the Vorbis comments from the FLAC file r
+func readFLACRawTags(r io.Reader) (rawTags, error) {
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	if string(b) != "fLaC" {
+		return nil, errRawTags
+	}
+
+	for {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		last := b[0]&0x80 != 0
+		size := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+
+		// vorbis comment block
+		if b[0]&0x7f == 4 {
+			data := make([]byte, size)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			return parseVorbisComment(data)
+		}
+		if last {
+			return nil, errRawTags
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(size)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// readOGGRawTags reads the Vorbis comments from the Ogg file r. These are
+// contained in the second packet of the logical stream (for Vorbis as well as
+// for Opus)
+func readOGGRawTags(r io.Reader) (rawTags, error) {
+	var (
+		packet  []byte
+		packets int
+	)
+	header := make([]byte, 27)
+
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+		if string(header[:4]) != "OggS" {
+			return nil, errRawTags
+		}
+		segments := make([]byte, header[26])
+		if _, err := io.ReadFull(r, segments); err != nil {
+			return nil, err
+		}
+		for _, seg := range segments {
+			data := make([]byte, seg)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return nil, err
+			}
+			packet = append(packet, data...)
+			if len(packet) > maxRawTagSize {
+				return nil, errRawTags
+			}
+			// a segment shorter than 255 bytes finalizes a packet
+			if seg == 255 {
+				continue
+			}
+			packets++
+			if packets == 2 {
+				switch {
+				case bytes.HasPrefix(packet, []byte("\x03vorbis")):
+					return parseVorbisComment(packet[7:])
+				case bytes.HasPrefix(packet, []byte("OpusTags")):
+					return parseVorbisComment(packet[8:])
+				}
+				return nil, errRawTags
+			}
+			packet = nil
+		}
+	}
+}
+
+// parseVorbisComment parses a Vorbis comment structure (without framing bit)
+func parseVorbisComment(b []byte) (rawTags, error) {
+	r := bytes.NewReader(b)
+	readString := func() (string, error) {
+		var n uint32
+		if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+			return "", err
+		}
+		if int64(n) > int64(r.Len()) {
+			return "", errRawTags
+		}
+		s := make([]byte, n)
+		if _, err := io.ReadFull(r, s); err != nil {
+			return "", err
+		}
+		return string(s), nil
+	}
+
+	// vendor string
+	if _, err := readString(); err != nil {
+		return nil, err
+	}
+
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	raw := make(rawTags)
+	for i := uint32(0); i < n; i++ {
+		s, err := readString()
+		if err != nil {
+			return nil, err
+		}
+		k, v, found := strings.Cut(s, "=")
+		if !found {
+			continue
+		}
+		k = strings.ToLower(k)
+		raw[k] = append(raw[k], v)
+	}
+
+	return raw, nil
+}
+
+// readID3v2RawTags reads the text frames (and the movement frames MVNM and MVIN)
+// of an ID3v2.3 or ID3v2.4 tag from r.
+// Frames that are compressed or encrypted are ignored
+func readID3v2RawTags(r io.Reader) (rawTags, error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:3]) != "ID3" {
+		return nil, errRawTags
+	}
+	version := header[3]
+	if version != 3 && version != 4 {
+		return nil, errRawTags
+	}
+	unsync := header[5]&0x80 != 0
+	extended := header[5]&0x40 != 0
+
+	size := synchsafe(header[6:10])
+	if size > maxRawTagSize {
+		return nil, errRawTags
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	// for ID3v2.3 the unsynchronisation is applied to the entire tag, for
+	// ID3v2.4 to each frame
+	if unsync && version == 3 {
+		data = removeUnsync(data)
+	}
+
+	// skip extended header
+	if extended {
+		if len(data) < 4 {
+			return nil, errRawTags
+		}
+		n := int(synchsafe(data[:4]))
+		if version == 3 {
+			n = int(binary.BigEndian.Uint32(data[:4])) + 4
+		}
+		if n > len(data) {
+			return nil, errRawTags
+		}
+		data = data[n:]
+	}
+
+	raw := make(rawTags)
+	for len(data) >= 10 {
+		id := string(data[:4])
+		// padding reached
+		if data[0] == 0 {
+			break
+		}
+		n := int(binary.BigEndian.Uint32(data[4:8]))
+		if version == 4 {
+			n = int(synchsafe(data[4:8]))
+		}
+		flags := data[9]
+		if n > len(data)-10 {
+			break
+		}
+		frame := data[10 : 10+n]
+		data = data[10+n:]
+
+		// besides text frames, the movement frames (which have the same
+		// structure) are read
+		if id[0] != 'T' && id != "MVNM" && id != "MVIN" {
+			continue
+		}
+
+		// handle frame flags
+		if version == 3 {
+			// compression or encryption
+			if flags&0xc0 != 0 {
+				continue
+			}
+			// grouping identity
+			if flags&0x20 != 0 && len(frame) > 0 {
+				frame = frame[1:]
+			}
+		} else {
+			// compression or encryption
+			if flags&0x0c != 0 {
+				continue
+			}
+			// grouping identity
+			if flags&0x40 != 0 && len(frame) > 0 {
+				frame = frame[1:]
+			}
+			// data length indicator
+			if flags&0x01 != 0 {
+				if len(frame) < 4 {
+					continue
+				}
+				frame = frame[4:]
+			}
+			if flags&0x02 != 0 || unsync {
+				frame = removeUnsync(frame)
+			}
+		}
+		if len(frame) == 0 {
+			continue
+		}
+
+		values := decodeID3v2Text(frame[0], frame[1:])
+		key := strings.ToLower(id)
+		if id == "TXXX" {
+			// the first value is the description of the frame
+			if len(values) < 2 {
+				continue
+			}
+			key = "txxx:" + strings.ToLower(values[0])
+			values = values[1:]
+		}
+		raw[key] = append(raw[key], values...)
+	}
+
+	return raw, nil
+}
+
+// decodeID3v2Text decodes the content of an ID3v2 text frame with encoding enc
+// and splits it into the null-separated values
+func decodeID3v2Text(enc byte, b []byte) (values []string) {
+	var texts []string
+
+	switch enc {
+	case 1, 2:
+		// UTF-16 (with BOM or big endian)
+		var units []uint16
+		order := binary.ByteOrder(binary.BigEndian)
+		for i := 0; i+1 < len(b); i += 2 {
+			u := order.Uint16(b[i : i+2])
+			if enc == 1 && (u == 0xfeff || u == 0xfffe) {
+				if u == 0xfffe {
+					order = binary.LittleEndian
+				}
+				continue
+			}
+			if u == 0 {
+				texts = append(texts, string(utf16.Decode(units)))
+				units = nil
+				continue
+			}
+			units = append(units, u)
+		}
+		texts = append(texts, string(utf16.Decode(units)))
+	case 3:
+		// UTF-8
+		texts = strings.Split(string(b), "\x00")
+	default:
+		// ISO-8859-1
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		texts = strings.Split(string(runes), "\x00")
+	}
+
+	for _, text := range texts {
+		if len(text) > 0 {
+			values = append(values, text)
+		}
+	}
+	return
+}
+
+// mp4RawTags takes the tags from MP4 metadata m. Package tag joins multiple
+// values of freeform atoms with ";". Thus, the values of the freeform atoms
+// that can have multiple values are split again
+func mp4RawTags(m tag.Metadata) rawTags {
+	raw := make(rawTags)
+	for k, v := range m.Raw() {
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		// the names of standard atoms can start with "\xa9". Since that's no
+		// valid UTF-8, only the remainder of such names is converted to lower
+		// case
+		if strings.HasPrefix(k, "\xa9") {
+			k = "\xa9" + strings.ToLower(k[1:])
+		} else {
+			k = strings.ToLower(k)
+		}
+		if _, isMulti := mp4MultiAtoms[k]; isMulti {
+			raw[k] = strings.Split(s, ";")
+			continue
+		}
+		raw[k] = []string{s}
+	}
+	return raw
+}
+
+// mp4MultiAtoms contains the names of the freeform MP4 atoms (in lower case)
+// that can have multiple values
+var mp4MultiAtoms = map[string]struct{}{
+	"albumartist":  {},
+	"album artist": {},
+	"artists":      {},
+	"composer":     {},
+	"conductor":    {},
+	"ensemble":     {},
+	"genre":        {},
+	"orchestra":    {},
+	"performer":    {},
+}
+
+// synchsafe decodes a synchsafe integer (i.e. an integer where only the lower 7
+// bits of each byte are used)
+func synchsafe(b []byte) uint32 {
+	var n uint32
+	for _, c := range b {
+		n = n<<7 | uint32(c&0x7f)
+	}
+	return n
+}
+
+// removeUnsync reverts the ID3v2 unsynchronisation scheme (i.e. each 0xff 0x00
+// sequence is replaced by 0xff)
+func removeUnsync(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xff, 0x00}, []byte{0xff})
+}
@@ -0,0 +1,337 @@
+package content
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// id3v2Frame is a frame of an ID3v2 tag that is assembled by id3v2Tag
+type id3v2Frame struct {
+	id    string
+	flags byte // second flag byte (format flags)
+	data  []byte
+}
+
+// id3v2Tag assembles an ID3v2 tag of version version with header flags flags
+// and the frames frames. ext is the extended header (if any). padding is the
+// number of padding bytes
+func id3v2Tag(version, flags byte, ext []byte, frames []id3v2Frame, padding int) []byte {
+	var body bytes.Buffer
+	body.Write(ext)
+	for _, f := range frames {
+		body.WriteString(f.id)
+		if version == 4 {
+			body.Write(synchsafeBytes(uint32(len(f.data))))
+		} else {
+			_ = binary.Write(&body, binary.BigEndian, uint32(len(f.data)))
+		}
+		body.Write([]byte{0, f.flags})
+		body.Write(f.data)
+	}
+	body.Write(make([]byte, padding))
+
+	var b bytes.Buffer
+	b.WriteString("ID3")
+	b.Write([]byte{version, 0, flags})
+	b.Write(synchsafeBytes(uint32(body.Len())))
+	b.Write(body.Bytes())
+	return b.Bytes()
+}
+
+// synchsafeBytes encodes n as synchsafe integer
+func synchsafeBytes(n uint32) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+// text returns the content of a text frame with encoding enc
+func text(enc byte, s string) []byte { return append([]byte{enc}, s...) }
+
+func TestReadID3v2RawTags(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  []byte
+		want rawTags
+		err  bool
+	}{
+		{
+			name: "ID3v2.4 with multiple UTF-8 values",
+			tag: id3v2Tag(4, 0, nil, []id3v2Frame{
+				{id: "TPE1", data: text(3, "A\x00B")},
+				{id: "TCON", data: text(3, "Rock\x00\x00Jazz\x00")},
+				{id: "TIT2", data: text(0, "Title")},
+			}, 10),
+			want: rawTags{"tpe1": {"A", "B"}, "tcon": {"Rock", "Jazz"}, "tit2": {"Title"}},
+		},
+		{
+			name: "ID3v2.3 with UTF-16 and TXXX",
+			tag: id3v2Tag(3, 0, nil, []id3v2Frame{
+				{id: "TPE1", data: []byte{1, 0xff, 0xfe, 'A', 0, 0, 0, 0xff, 0xfe, 'B', 0}},
+				{id: "TXXX", data: text(3, "Work\x00Symphony No. 5")},
+				{id: "TXXX", data: text(3, "empty")},
+			}, 0),
+			want: rawTags{"tpe1": {"A", "B"}, "txxx:work": {"Symphony No. 5"}},
+		},
+		{
+			name: "movement frames are read, other frames are ignored",
+			tag: id3v2Tag(4, 0, nil, []id3v2Frame{
+				{id: "APIC", data: []byte{0, 'x'}},
+				{id: "MVNM", data: text(3, "Allegro")},
+				{id: "MVIN", data: text(3, "1/4")},
+				{id: "COMM", data: text(3, "comment")},
+			}, 0),
+			want: rawTags{"mvnm": {"Allegro"}, "mvin": {"1/4"}},
+		},
+		{
+			name: "ID3v2.4 frame with data length indicator and unsynchronisation",
+			tag: id3v2Tag(4, 0, nil, []id3v2Frame{
+				{id: "TIT2", flags: 0x03, data: append(synchsafeBytes(3), 0, 0xff, 0x00, 'x')},
+			}, 0),
+			want: rawTags{"tit2": {"ÿx"}},
+		},
+		{
+			name: "ID3v2.4 frames with unsynchronisation flag in tag header",
+			tag: id3v2Tag(4, 0x80, nil, []id3v2Frame{
+				{id: "TIT2", data: []byte{0, 0xff, 0x00, 'x'}},
+			}, 0),
+			want: rawTags{"tit2": {"ÿx"}},
+		},
+		{
+			name: "ID3v2.4 frame with grouping identity",
+			tag: id3v2Tag(4, 0, nil, []id3v2Frame{
+				{id: "TIT2", flags: 0x40, data: append([]byte{7}, text(3, "Title")...)},
+			}, 0),
+			want: rawTags{"tit2": {"Title"}},
+		},
+		{
+			name: "compressed and encrypted frames are ignored",
+			tag: id3v2Tag(4, 0, nil, []id3v2Frame{
+				{id: "TIT2", flags: 0x08, data: text(3, "compressed")},
+				{id: "TALB", flags: 0x04, data: text(3, "encrypted")},
+				{id: "TPE1", data: text(3, "A")},
+			}, 0),
+			want: rawTags{"tpe1": {"A"}},
+		},
+		{
+			name: "ID3v2.3 compressed frame is ignored",
+			tag: id3v2Tag(3, 0, nil, []id3v2Frame{
+				{id: "TIT2", flags: 0x80, data: text(3, "compressed")},
+				{id: "TPE1", data: text(3, "A")},
+			}, 0),
+			want: rawTags{"tpe1": {"A"}},
+		},
+		{
+			name: "ID3v2.3 extended header",
+			tag: id3v2Tag(3, 0x40, []byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0}, []id3v2Frame{
+				{id: "TPE1", data: text(3, "A")},
+			}, 0),
+			want: rawTags{"tpe1": {"A"}},
+		},
+		{
+			name: "ID3v2.4 extended header",
+			tag: id3v2Tag(4, 0x40, []byte{0, 0, 0, 6, 1, 0}, []id3v2Frame{
+				{id: "TPE1", data: text(3, "A")},
+			}, 0),
+			want: rawTags{"tpe1": {"A"}},
+		},
+		{
+			name: "ID3v2.3 tag with unsynchronisation",
+			tag: func() []byte {
+				// frame sizes refer to the data after the unsynchronisation
+				// is reverted
+				b := id3v2Tag(3, 0x80, nil, []id3v2Frame{
+					{id: "TIT2", data: []byte{0, 0xff, 'x'}},
+				}, 0)
+				b = append(b[:22], append([]byte{0x00}, b[22:]...)...)
+				copy(b[6:], synchsafeBytes(uint32(len(b)-10)))
+				return b
+			}(),
+			want: rawTags{"tit2": {"ÿx"}},
+		},
+		{
+			name: "frame that exceeds the tag",
+			tag: func() []byte {
+				b := id3v2Tag(4, 0, nil, []id3v2Frame{
+					{id: "TPE1", data: text(3, "A")},
+					{id: "TIT2", data: text(3, "Title")},
+				}, 0)
+				// increase the size of the second frame
+				copy(b[10+10+2+4:], synchsafeBytes(100))
+				return b
+			}(),
+			want: rawTags{"tpe1": {"A"}},
+		},
+		{
+			name: "ID3v2.2",
+			tag:  id3v2Tag(2, 0, nil, nil, 0),
+			err:  true,
+		},
+		{
+			name: "no ID3v2 tag",
+			tag:  []byte("fLaC\x00\x00\x00\x00\x00\x00"),
+			err:  true,
+		},
+		{
+			name: "truncated tag",
+			tag:  id3v2Tag(4, 0, nil, []id3v2Frame{{id: "TPE1", data: text(3, "A")}}, 0)[:15],
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := readID3v2RawTags(bytes.NewReader(tt.tag))
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, want error = %t", tt.name, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: readID3v2RawTags() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeID3v2Text(t *testing.T) {
+	tests := []struct {
+		name string
+		enc  byte
+		b    []byte
+		want []string
+	}{
+		{"ISO-8859-1", 0, []byte{'a', 0xe4, 0, 'b'}, []string{"aä", "b"}},
+		{"UTF-16 little endian", 1, []byte{0xff, 0xfe, 'a', 0, 0xe4, 0}, []string{"aä"}},
+		{"UTF-16 big endian with BOM", 1, []byte{0xfe, 0xff, 0, 'a'}, []string{"a"}},
+		{"UTF-16BE", 2, []byte{0, 'a', 0, 0, 0, 'b'}, []string{"a", "b"}},
+		{"UTF-16 surrogate pair", 2, []byte{0xd8, 0x3d, 0xde, 0x00}, []string{"\U0001f600"}},
+		{"UTF-8", 3, []byte("a\x00\x00b\x00"), []string{"a", "b"}},
+		{"empty", 3, nil, nil},
+	}
+	for _, tt := range tests {
+		if got := decodeID3v2Text(tt.enc, tt.b); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: decodeID3v2Text() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// vorbisComment assembles a Vorbis comment structure with the vendor string
+// vendor and the comments comments
+func vorbisComment(vendor string, comments ...string) []byte {
+	var b bytes.Buffer
+	_ = binary.Write(&b, binary.LittleEndian, uint32(len(vendor)))
+	b.WriteString(vendor)
+	_ = binary.Write(&b, binary.LittleEndian, uint32(len(comments)))
+	for _, c := range comments {
+		_ = binary.Write(&b, binary.LittleEndian, uint32(len(c)))
+		b.WriteString(c)
+	}
+	return b.Bytes()
+}
+
+func TestParseVorbisComment(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+		want rawTags
+		err  bool
+	}{
+		{
+			name: "multiple values",
+			b:    vorbisComment("vendor", "ARTIST=A", "artist=B", "TITLE=T=1", "invalid", "GENRE="),
+			want: rawTags{"artist": {"A", "B"}, "title": {"T=1"}, "genre": {""}},
+		},
+		{
+			name: "no comments",
+			b:    vorbisComment(""),
+			want: rawTags{},
+		},
+		{
+			name: "truncated",
+			b:    vorbisComment("vendor", "ARTIST=A")[:20],
+			err:  true,
+		},
+		{
+			name: "comment length exceeds data",
+			b:    append(vorbisComment("vendor")[:10], 0xff, 0, 0, 0, 1, 0, 0, 0, 'x'),
+			err:  true,
+		},
+		{
+			name: "empty",
+			b:    nil,
+			err:  true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseVorbisComment(tt.b)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: error = %v, want error = %t", tt.name, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: parseVorbisComment() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMP4RawTags(t *testing.T) {
+	m := rawMetadata{raw: map[string]interface{}{
+		"\xa9ART":   "A;B",
+		"ARTISTS":   "A;B",
+		"Conductor": "C",
+		"title":     "X;Y",
+		"trkn":      3,
+	}}
+	want := rawTags{
+		"\xa9art":   {"A;B"},
+		"artists":   {"A", "B"},
+		"conductor": {"C"},
+		"title":     {"X;Y"},
+	}
+	if got := mp4RawTags(m); !reflect.DeepEqual(got, want) {
+		t.Errorf("mp4RawTags() = %q, want %q", got, want)
+	}
+}
+
+func TestRawTagsGet(t *testing.T) {
+	raw := rawTags{"tpe1": {"A"}, "artist": {}, "tpe2": {"B"}}
+	if got := raw.get("artist", "tpe1", "tpe2"); !reflect.DeepEqual(got, []string{"A"}) {
+		t.Errorf("get() = %q, want [A]", got)
+	}
+	if got := raw.get("tcom"); got != nil {
+		t.Errorf("get() = %q, want nil", got)
+	}
+}
+
+func TestSynchsafe(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		want uint32
+	}{
+		{[]byte{0, 0, 0, 0x7f}, 127},
+		{[]byte{0, 0, 1, 0}, 128},
+		{[]byte{0, 0, 2, 1}, 257},
+		{[]byte{0x7f, 0x7f, 0x7f, 0x7f}, 1<<28 - 1},
+		// the most significant bit of each byte is ignored
+		{[]byte{0, 0, 0x81, 0x80}, 128},
+	}
+	for _, tt := range tests {
+		if got := synchsafe(tt.b); got != tt.want {
+			t.Errorf("synchsafe(%v) = %d, want %d", tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestRemoveUnsync(t *testing.T) {
+	tests := []struct {
+		b    []byte
+		want []byte
+	}{
+		{[]byte{0xff, 0x00, 0xe0}, []byte{0xff, 0xe0}},
+		{[]byte{0xff, 0x00, 0x00}, []byte{0xff, 0x00}},
+		{[]byte{0xff, 0xe0}, []byte{0xff, 0xe0}},
+		{[]byte{0x00, 0xff}, []byte{0x00, 0xff}},
+	}
+	for _, tt := range tests {
+		if got := removeUnsync(tt.b); !bytes.Equal(got, tt.want) {
+			t.Errorf("removeUnsync(%v) = %v, want %v", tt.b, got, tt.want)
+		}
+	}
+}
@@ -12,22 +12,20 @@ import (
 // scanning run, that regularly scans the music directory for changes that must
 // be applied to the muserv content
 type scanner struct {
-	updNotif     chan UpdateNotification
-	upd          chan struct{}
-	errs         chan error
-	filesByPaths func([]string) *fileInfos
-	update       func(context.Context, *fileInfos, *fileInfos) (uint32, error)
+	updNotif chan UpdateNotification
+	upd      chan struct{}
+	errs     chan error
+	cnt      *Content
 }
 
-// newScanner creates a new scanner instance
-func newScanner(filesByPaths func([]string) *fileInfos, update func(context.Context, *fileInfos, *fileInfos) (uint32, error)) *scanner {
+// newScanner creates a new scanner instance for the content cnt
+func newScanner(cnt *Content) *scanner {
 	sc := new(scanner)
 
 	sc.errs = make(chan error)
 	sc.updNotif = make(chan UpdateNotification)
 	sc.upd = make(chan struct{})
-	sc.filesByPaths = filesByPaths
-	sc.update = update
+	sc.cnt = cnt
 
 	return sc
 }
@@ -68,7 +66,7 @@ func (me *scanner) run(ctx context.Context, wg *sync.WaitGroup) {
 					wg.Done()
 				}()
 
-				fiDel, fiAdd := fullScan(cfg.Cnt.MusicDirs, me.filesByPaths)
+				fiDel, fiAdd := me.cnt.fullScan(cfg.Cnt.MusicDirs)
 
 				// channel to notify server about finalized update
 				updated := make(chan uint32)
@@ -88,7 +86,7 @@ func (me *scanner) run(ctx context.Context, wg *sync.WaitGroup) {
 				// or added objects
 				var count uint32
 				var err error
-				if count, err = me.update(ctx, fiDel, fiAdd); err != nil {
+				if count, err = me.cnt.update(ctx, fiDel, fiAdd); err != nil {
 					me.errs <- err
 					return
 				}
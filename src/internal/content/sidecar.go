@@ -0,0 +1,78 @@
+package content
+
+// this file contains the logic for sidecar files. These are files that are
+// stored next to the track files and that contain additional data for the
+// tracks (such as .lrc files with lyrics, .nfo files, tag overrides or
+// directory rules). Changes of sidecar files are treated as changes of the
+// corresponding tracks
+
+import (
+	"os"
+	p "path"
+	"strings"
+	"syscall"
+
+	"gitlab.com/mipimipi/muserv/src/internal/config"
+)
+
+// sidecarPaths returns the paths of the possible sidecar files of the track
+// file path
+func sidecarPaths(path string) []string {
+	return append(append([]string{lrcPath(path)}, albumNFOPaths(path)...), artistNFOPaths(path)...)
+}
+
+// isSidecar returns true if path is a sidecar file
+func (me *Content) isSidecar(path string) bool {
+	return p.Ext(path) == lrcExt || isNFO(path) || isRuleFile(path) || me.overrides.isOverridesFile(path)
+}
+
+// pathsOfSidecar returns the paths that the sidecar file path belongs to. For
+// tag overrides files, these are the tracks that are affected by the change
+// (see tagOverrides.affectedPaths). For .nfo files and directory rule files,
+// that's the directory of the file. Otherwise, these are the track files in
+// the same directory with the same name (apart from the extension)
+func (me *Content) pathsOfSidecar(path string) (paths []string) {
+	if me.overrides.isOverridesFile(path) {
+		return me.overrides.affectedPaths(path, me.paths.below)
+	}
+	if isNFO(path) || isRuleFile(path) {
+		return []string{p.Dir(path)}
+	}
+
+	dir, name := p.Split(path)
+	name = strings.TrimSuffix(name, p.Ext(name))
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.TrimSuffix(entry.Name(), p.Ext(entry.Name())) != name {
+			continue
+		}
+		if trackPath := p.Join(dir, entry.Name()); config.IsValidTrackFile(trackPath) {
+			paths = append(paths, trackPath)
+		}
+	}
+	return
+}
+
+// sidecarsLastChange returns the time of the last change (in UNIX format) of
+// the sidecar files of the track file path. Tag overrides files are only
+// considered if they contain entries for the track. Of the directory rules,
+// only the .muserv.json files are relevant (.museignore files don't change
+// tracks but determine whether they are part of the content). If there are no
+// sidecar files, 0 is returned
+func (me *Content) sidecarsLastChange(path string) (lastChange int64) {
+	_, lastChange = me.overrides.get(path)
+	_, settingsChange := me.rules.settings(path)
+	lastChange = max(lastChange, settingsChange)
+	for _, sc := range sidecarPaths(path) {
+		info, err := os.Stat(sc)
+		if err != nil {
+			continue
+		}
+		lastChange = max(lastChange, int64(info.Sys().(*syscall.Stat_t).Ctim.Sec))
+	}
+	return
+}
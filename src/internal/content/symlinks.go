@@ -0,0 +1,162 @@
+package content
+
+// this file contains the logic for following symbolic links in the music
+// directories (see configuration option follow_symlinks). Tracks always keep
+// their logical path, i.e. the path that contains the link. The targets of the
+// links are registered, so that file system changes in the targets (which
+// inotify reports with the resolved paths) can be mapped to the logical paths
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	f "gitlab.com/go-utilities/file"
+	"gitlab.com/mipimipi/muserv/src/internal/config"
+)
+
+// symlinks registers the targets of the symbolic links that have been followed
+// during the traversal of the music directories. Since the directories are
+// traversed concurrently, all access is synchronized
+type symlinks struct {
+	mu      sync.Mutex
+	cfg     *config.Cfg
+	targets map[string]map[string]struct{} // logical paths per link target (resolved path)
+}
+
+// newSymlinks creates a new symlinks instance from the configuration. nil is
+// returned if symbolic links shall not be followed. Music directories that are
+// symbolic links themselves are registered right away
+func newSymlinks(cfg *config.Cfg) *symlinks {
+	if !cfg.Cnt.FollowSymlinks {
+		return nil
+	}
+	sl := symlinks{
+		cfg:     cfg,
+		targets: make(map[string]map[string]struct{}),
+	}
+	for _, dir := range cfg.Cnt.MusicDirs {
+		if target, err := filepath.EvalSymlinks(dir); err == nil && target != dir {
+			sl.register(target, dir)
+		}
+	}
+	return &sl
+}
+
+// register adds the logical path of a link to its target
+func (me *symlinks) register(target, path string) {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	if _, exists := me.targets[target]; !exists {
+		me.targets[target] = make(map[string]struct{})
+	}
+	me.targets[target][path] = struct{}{}
+}
+
+// isSymlink returns true if path is a symbolic link
+func isSymlink(path string) bool {
+	info, err := os.Lstat(path)
+	return err == nil && info.Mode()&os.ModeSymlink != 0
+}
+
+// follow resolves the symbolic link path and registers its target. It returns
+// the file info of the target with the logical path. ok is false if the link
+// cannot be resolved or if it leads to a cycle
+func (me *symlinks) follow(path string) (fi f.Info, ok bool) {
+	target, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		fi, err = f.Stat(path)
+	}
+	if err != nil {
+		log.Warnf("symbolic link '%s' cannot be resolved: %v", path, err)
+		return nil, false
+	}
+	if fi.IsDir() && me.isCycle(path, target) {
+		log.Warnf("symbolic link '%s' is skipped since it leads to a cycle", path)
+		return nil, false
+	}
+	me.register(target, path)
+	return fi, true
+}
+
+// isCycle returns true if the directory target that the link path points to
+// contains one of the directories on the logical path from the music
+// directory down to the link. Following the link would lead to a directory
+// that is already being traversed in that case
+func (me *symlinks) isCycle(path, target string) bool {
+	root := me.cfg.Cnt.MusicDir(path)
+	if len(root) == 0 {
+		root = filepath.Dir(path)
+	}
+	for _, dir := range dirsBelow(root, filepath.Dir(path)) {
+		resolved, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		if resolved == target || strings.HasPrefix(resolved, target+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// logicalPaths maps path to the logical paths that lead to it via symbolic
+// links. path itself is part of the result unless it's only reachable via
+// links. Links that have been removed or changed in the meantime are removed
+// from the registry
+func (me *symlinks) logicalPaths(path string) []string {
+	if me == nil {
+		return []string{path}
+	}
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	var paths []string
+	var mapped bool
+	for target, logical := range me.targets {
+		if path != target && !strings.HasPrefix(path, target+"/") {
+			continue
+		}
+		mapped = true
+		for lp := range logical {
+			// if the link cannot be resolved anymore, the change is still
+			// mapped to it, so that the tracks below the link are removed
+			resolved, err := filepath.EvalSymlinks(lp)
+			if err != nil || resolved != target {
+				delete(logical, lp)
+			}
+			if err == nil && resolved != target {
+				continue
+			}
+			paths = append(paths, lp+strings.TrimPrefix(path, target))
+		}
+		if len(logical) == 0 {
+			delete(me.targets, target)
+		}
+	}
+	if !mapped || len(me.cfg.Cnt.MusicDir(path)) > 0 {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// targetPaths returns the registered link targets
+func (me *symlinks) targetPaths() (paths []string) {
+	if me == nil {
+		return
+	}
+
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	for target := range me.targets {
+		paths = append(paths, target)
+	}
+	sort.Strings(paths)
+	return
+}
@@ -3,6 +3,7 @@ package content
 import (
 	"fmt"
 	"mime"
+	"os"
 	"path"
 	"strings"
 	"sync"
@@ -21,8 +22,16 @@ type track struct {
 	picID      nonePicID           // ID of the cover picture (can be "null")
 	mimeType   string              // mime type of track file
 	size       int64               // size of track file in bytes
-	lastChange int64               // UNIX time of last change of track file
+	lastChange int64               // UNIX time of last change of track file (kept if the track is moved)
+	fileChange int64               // UNIX time of last change of track file when it was read (to detect changes)
+	added      int64               // UNIX time when track was added (see added.go)
 	path       string              // path of track file
+	hasLRC     bool                // track has an .lrc file with time-synced lyrics
+	albumNFO   *nfo                // data from album.nfo file (nil if there's none)
+	artistNFO  *nfo                // data from artist.nfo file (nil if there's none)
+	file       fileID              // device and inode number of track file
+	mtime      int64               // UNIX time of last modification of track file content
+	pathHash   uint64              // hash of the settings that depend on the path (see moves.go)
 	refs       map[ObjID]*trackRef // corresponding track references
 }
 
@@ -36,16 +45,20 @@ func newTrack(cnt *Content, wg *sync.WaitGroup, count *uint32, ti trackInfo) (t
 	)
 
 	// get tags and picture
-	if tgs, picture, err = ti.metadata(cnt.cfg.Cnt.Separator); err != nil {
+	if tgs, picture, err = ti.metadata(cnt); err != nil {
 		err = errors.Wrapf(err, "cannot create track from filepath '%s'", ti.path())
 		log.Fatal(err)
 		return
 	}
+	// detect compilations and complete album artists
+	cnt.completeAlbumArtists(ti.path(), tgs)
 	// get size of track
 	size = ti.size()
 	// get last changed time of track
 	lastChange = ti.lastChange()
 
+	file, mtime := fileIDOf(ti.info())
+
 	t = &track{
 		newItm(cnt, cnt.newID(), tgs.title),
 		tgs,
@@ -53,41 +66,77 @@ func newTrack(cnt *Content, wg *sync.WaitGroup, count *uint32, ti trackInfo) (t
 		ti.mimeType(),
 		size,
 		lastChange,
+		lastChange,
+		cnt.addedCache.get(ti.path()),
 		ti.path(),
+		false,
+		nil,
+		nil,
+		file,
+		mtime,
+		cnt.pathHash(ti.path()),
 		make(map[ObjID]*trackRef),
 	}
-	t.marshalFunc = newTrackMarshalFunc(t, cnt.extMusicPath, cnt.extPicturePath)
+	t.marshalFunc = newTrackMarshalFunc(t, cnt.extMusicPath, cnt.extPicturePath, cnt.extLyricsPath)
+
+	t.setPathData()
 
 	cnt.tracks.add(t)
+	cnt.paths.add(t.path, infoTrack)
 	cnt.objects.add(t)
 
 	// process picture
-	wg.Add(1)
-	go cnt.pictures.add(wg, picture, &t.picID)
+	cnt.pictures.add(wg, picture, &t.picID)
 
 	// count creation of track object
 	*count++
 
-	// add track to corresponding album. Create it if is doesn't exist
-	if len(t.tags.album) > 0 {
-		a, exists := cnt.albums[t.albumKey()]
-		if !exists {
-			a = newAlbum(cnt, t.albumKey())
-			a.n = t.tags.album
-			a.year = t.tags.year
-			a.compilation = t.tags.compilation
-			a.artists = t.tags.albumArtists
-			a.composers = t.tags.composers
-			a.lastChange = t.lastChange
-		}
-		a.addChild(t)
-		// count change of album container
-		*count++
-	}
+	cnt.addTrackToAlbum(count, t)
 
 	return
 }
 
+// setPathData sets the data of the track that is taken from files next to the
+// track file (.lrc and .nfo files) and requests the loudness measurement if
+// the tags don't contain loudness data
+func (me *track) setPathData() {
+	// check if there's an .lrc file
+	_, err := os.Stat(lrcPath(me.path))
+	me.hasLRC = err == nil
+
+	// get data from .nfo files (if there are any)
+	me.albumNFO = me.cnt.nfoOf(albumNFOPaths(me.path))
+	me.artistNFO = me.cnt.nfoOf(artistNFOPaths(me.path))
+
+	// request loudness measurement if the tags don't contain loudness data
+	if me.cnt.loudnessCache != nil && !me.tags.loudness.track.valid && isMeasurable(me.path) {
+		me.cnt.loudnessCache.request(me.path, me.mtime)
+	}
+}
+
+// addTrackToAlbum adds the track t to its album. The album is created if it
+// doesn't exist
+func (me *Content) addTrackToAlbum(count *uint32, t *track) {
+	if len(t.tags.album) == 0 {
+		return
+	}
+	a, exists := me.albums[t.albumKey()]
+	if !exists {
+		a = newAlbum(me, t.albumKey())
+		a.n = t.tags.album
+		a.year = t.tags.year
+		a.date = t.tags.date
+		a.originalDate = t.tags.originalDate
+		a.compilation = t.tags.compilation
+		a.artists = t.tags.albumArtists
+		a.composers = t.tags.composers
+		a.lastChange = t.added
+	}
+	a.addChild(t)
+	// count change of album container
+	*count++
+}
+
 // newExtTrack creates a new track object for an external track (i.e. a track
 // that is not stored in the file system but somewhere in the WWW)
 func newExtTrack(cnt *Content, count *uint32, url, title string) (t *track, err error) {
@@ -98,10 +147,18 @@ func newExtTrack(cnt *Content, count *uint32, url, title string) (t *track, err
 		mime.TypeByExtension(path.Ext(url)),
 		0,
 		0,
+		0,
+		0,
 		url,
+		false,
+		nil,
+		nil,
+		fileID{},
+		0,
+		0,
 		make(map[ObjID]*trackRef),
 	}
-	t.marshalFunc = newTrackMarshalFunc(t, cnt.extMusicPath, cnt.extPicturePath)
+	t.marshalFunc = newTrackMarshalFunc(t, cnt.extMusicPath, cnt.extPicturePath, cnt.extLyricsPath)
 
 	cnt.tracks.add(t)
 	cnt.objects.add(t)
@@ -157,12 +214,22 @@ func (me *track) newTrackRef(sfs []config.SortField) *trackRef {
 				s = fmt.Sprintf("%03d", me.tags.discNo)
 			case config.SortLastChange:
 				s = fmt.Sprintf("%020d", me.lastChange)
+			case config.SortAdded:
+				s = fmt.Sprintf("%020d", me.added)
 			case config.SortTitle:
 				s = me.tags.title
 			case config.SortTrackNo:
 				s = fmt.Sprintf("%04d", me.tags.trackNo)
 			case config.SortYear:
 				s = fmt.Sprintf("%d", me.tags.year)
+			case config.SortRating:
+				s = ratingSortString(me.tags.rating)
+			case config.SortMovementNo:
+				s = fmt.Sprintf("%04d", me.tags.movementNo)
+			case config.SortDate:
+				s = me.tags.date.sortString()
+			case config.SortOriginalYear:
+				s = fmt.Sprintf("%04d", originalYear(me.tags.originalDate, me.tags.year))
 			}
 			if len(s) > 0 {
 				tRef.sf = append(tRef.sf, s)
@@ -181,9 +248,20 @@ func (me *track) tagsByLevelType(lvl config.LevelType) []string {
 	case config.LvlGenre:
 		return me.tags.genres
 	case config.LvlAlbumArtist:
+		// if configured, all compilations are put into one container
+		if me.tags.compilation && me.cnt.cfg.Cnt.Compilations.SeparateContainer {
+			return []string{me.cnt.cfg.Cnt.Compilations.AlbumArtist}
+		}
 		return me.tags.albumArtists
 	case config.LvlArtist:
-		return me.tags.artists
+		// featured artists get their own artist nodes
+		return append(append([]string{}, me.tags.artists...), me.tags.featured...)
+	case config.LvlComposer:
+		return me.tags.composers
+	case config.LvlConductor:
+		return me.tags.conductors
+	case config.LvlWork:
+		return []string{me.tags.work}
 	}
 	return []string{}
 }
@@ -201,3 +279,37 @@ type trackRef struct {
 	*itm
 	track *track
 }
+
+// workDescription assembles a description of the work and movement of a track
+// from its tags (e.g. "Symphony No. 5: III. Allegro")
+func workDescription(tgs *tags) string {
+	movement := tgs.movementName
+	if tgs.movementNo > 0 && len(movement) > 0 {
+		movement = fmt.Sprintf("%s. %s", romanNumeral(tgs.movementNo), movement)
+	}
+	switch {
+	case len(tgs.work) == 0:
+		return movement
+	case len(movement) == 0:
+		return tgs.work
+	}
+	return tgs.work + ": " + movement
+}
+
+// romanNumeral converts n (1 <= n < 4000) into a roman numeral. For other
+// values of n, the decimal representation is returned
+func romanNumeral(n int) string {
+	if n < 1 || n >= 4000 {
+		return fmt.Sprint(n)
+	}
+	values := []int{1000, 900, 500, 400, 100, 90, 50, 40, 10, 9, 5, 4, 1}
+	symbols := []string{"M", "CM", "D", "CD", "C", "XC", "L", "XL", "X", "IX", "V", "IV", "I"}
+	var sb strings.Builder
+	for i := 0; i < len(values); i++ {
+		for n >= values[i] {
+			sb.WriteString(symbols[i])
+			n -= values[i]
+		}
+	}
+	return sb.String()
+}
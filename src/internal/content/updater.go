@@ -2,9 +2,12 @@ package content
 
 import (
 	"context"
+	"os"
+	"path/filepath"
 	"sort"
 	"sync"
 
+	"github.com/pkg/errors"
 	f "gitlab.com/go-utilities/file"
 	"gitlab.com/mipimipi/muserv/src/internal/config"
 )
@@ -37,31 +40,37 @@ type updater interface {
 const (
 	updModeNotify = "notify" // update via fsnotify
 	updModeScan   = "scan"   // update via regular scans
+	updModeHybrid = "hybrid" // update via fsnotify and regular scans
 )
 
 // updaters maps the update mode to its implementations
-var updaters = map[string](func(func([]string) *fileInfos, func(context.Context, *fileInfos, *fileInfos) (uint32, error)) updater){
-	updModeNotify: func(filesByPaths func([]string) *fileInfos, update func(context.Context, *fileInfos, *fileInfos) (uint32, error)) updater {
-		return newNotifier(filesByPaths, update)
+var updaters = map[string](func(*Content) updater){
+	updModeNotify: func(cnt *Content) updater {
+		return newNotifier(cnt)
 	},
-	updModeScan: func(filesByPaths func([]string) *fileInfos, update func(context.Context, *fileInfos, *fileInfos) (uint32, error)) updater {
-		return newScanner(filesByPaths, update)
+	updModeScan: func(cnt *Content) updater {
+		return newScanner(cnt)
+	},
+	updModeHybrid: func(cnt *Content) updater {
+		return newHybrid(cnt)
 	},
 }
 
-// newUpdater creates an updater instance based on cfg.UpdateMode
-func newUpdater(updMode string, filesByPaths func([]string) *fileInfos, update func(context.Context, *fileInfos, *fileInfos) (uint32, error)) updater {
+// newUpdater creates an updater instance for the content cnt based on
+// cfg.UpdateMode
+func newUpdater(updMode string, cnt *Content) updater {
 	upd, ok := updaters[updMode]
 	if ok {
-		return upd(filesByPaths, update)
+		return upd(cnt)
 	}
 	return nil
 }
 
 // filesFromDirs recursively determines all valid files of the folder tree
 // below each directory in dirs. Valid in this context means that the files
-// have a mime type that is supported by muserv
-func filesFromDirs(dirs []string) *fileInfos {
+// have a mime type that is supported by muserv and that they are not excluded
+// (see dirrules.go)
+func (me *Content) filesFromDirs(dirs []string) *fileInfos {
 	var fis fileInfos
 
 	log.Tracef("reading tracks from '%v' ...", dirs)
@@ -69,15 +78,44 @@ func filesFromDirs(dirs []string) *fileInfos {
 	var fileInfos = make(chan fileInfo)
 	defer close(fileInfos)
 
-	// filter: only accepts files that have the supported mime types
-	filter := func(srcFile f.Info, vp f.ValidPropagate) (bool, f.ValidPropagate) {
+	// filter: only accepts files that have the supported mime types and that
+	// are not excluded. Excluded directories are not traversed. Since f.Find
+	// skips symbolic links, they are followed here (if that's configured):
+	// Linked directories are traversed with the link as root
+	var filter func(f.Info, f.ValidPropagate) (bool, f.ValidPropagate)
+	filter = func(srcFile f.Info, vp f.ValidPropagate) (bool, f.ValidPropagate) {
+		if me.rules.isExcluded(srcFile.Path(), srcFile.IsDir()) {
+			return false, f.InvalidFromSuper
+		}
+		if srcFile.IsDir() && me.links != nil {
+			// f.Find skips directories that cannot be read silently. Thus,
+			// the error is logged here
+			entries, err := os.ReadDir(srcFile.Path())
+			if err != nil {
+				log.Error(errors.Wrapf(err, "cannot read directory '%s'", srcFile.Path()))
+			}
+			for _, entry := range entries {
+				if entry.Type()&os.ModeSymlink == 0 {
+					continue
+				}
+				fi, ok := me.links.follow(filepath.Join(srcFile.Path(), entry.Name()))
+				if !ok {
+					continue
+				}
+				if fi.IsDir() {
+					_ = f.Find([]f.Info{fi}, filter, 1)
+				} else if fi.Mode().IsRegular() {
+					_, _ = filter(fi, f.NoneFromSuper)
+				}
+			}
+		}
 		if !srcFile.IsDir() && srcFile.Mode().IsRegular() {
 			if config.IsValidPlaylistFile(srcFile.Path()) {
 				fileInfos <- newPlaylistInfo(srcFile.Path(), 0)
 				return true, f.NoneFromSuper
 			}
 			if config.IsValidTrackFile(srcFile.Path()) {
-				fileInfos <- newTrackInfo(srcFile.Path(), 0)
+				fileInfos <- newTrackInfo(me, srcFile.Path(), 0)
 				return true, f.NoneFromSuper
 			}
 		}
@@ -95,8 +133,14 @@ func filesFromDirs(dirs []string) *fileInfos {
 	// determine files according to filter
 	var roots []f.Info
 	for _, dir := range dirs {
-		root, err := f.Stat(dir)
-		if err != nil {
+		var root f.Info
+		var err error
+		if me.links != nil && isSymlink(dir) {
+			var ok bool
+			if root, ok = me.links.follow(dir); !ok {
+				continue
+			}
+		} else if root, err = f.Stat(dir); err != nil {
 			log.Error(err)
 			return &fis
 		}
@@ -137,8 +181,10 @@ func diff(fiCnt fileInfos, fiDir fileInfos) (fiDel, fiAdd fileInfos) {
 			continue
 		}
 		if fiCnt[i].path() == fiDir[j].path() {
-			// check is files have changed though the name didn't
-			if fiCnt[i].lastChange() < fiDir[j].lastChange() {
+			// check is files have changed though the name didn't. The time of
+			// the last change of a track can also decrease (e.g. if one of its
+			// sidecar files has been removed)
+			if fiCnt[i].lastChange() != fiDir[j].lastChange() {
 				fiDel = append(fiDel, fiCnt[i])
 				fiAdd = append(fiAdd, fiDir[j])
 			}
@@ -156,21 +202,24 @@ func diff(fiCnt fileInfos, fiDir fileInfos) (fiDel, fiAdd fileInfos) {
 //	     and (b) determines and returns the differences (i.e. which files must
 //		            be deleted from and added to the content hierarchies to make it
 //	             consistent with the music dir)
-func fullScan(musicDirs []string, filesByPaths func([]string) *fileInfos) (*fileInfos, *fileInfos) {
+func (me *Content) fullScan(musicDirs []string) (*fileInfos, *fileInfos) {
 	log.Trace("scanning ...")
 
+	// music dirs that are not available are skipped to keep their content
+	musicDirs = me.avail.availableDirs(musicDirs, me.paths.hasAny)
+
 	// get changes / differences between music directory and muserv content
 	cntData := make(chan *fileInfos)
 	dirData := make(chan *fileInfos)
 
 	// retrieve files from content
 	go func(ret chan<- *fileInfos) {
-		ret <- filesByPaths(musicDirs)
+		ret <- me.filesByPaths(musicDirs)
 	}(cntData)
 
 	// retrieve files from music dir
 	go func(musicDirs []string, ret chan<- *fileInfos) {
-		ret <- filesFromDirs(musicDirs)
+		ret <- me.filesFromDirs(musicDirs)
 	}(musicDirs, dirData)
 
 	fiCnt := <-cntData
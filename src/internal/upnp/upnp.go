@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/xml"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
 	"path/filepath"
@@ -45,7 +46,9 @@ const (
 	albumsSpreadAcrossMultipleDirs     = "albums-spread-across-multiple-directories"
 	albumsWithInconsistentTrackNumbers = "albums-with-inconsistent-track-numbers"
 	albumsWithMultipleCovers           = "albums-with-multiple-covers"
+	albumsWithoutLoudness              = "albums-without-loudness"
 	inconsistentAlbums                 = "inconsistent-albums"
+	tracksWithCharsetIssues            = "tracks-with-charset-issues"
 	tracksWithoutAlbum                 = "tracks-without-album"
 	tracksWithoutCover                 = "tracks-without-cover"
 )
@@ -444,6 +447,49 @@ func (me *Server) setHTTPHandler() {
 		},
 	)
 
+	// handler for requests to lyrics folder. Unsynchronized lyrics are
+	// requested as "<TRACK-ID>", time-synced lyrics as "<TRACK-ID>.lrc"
+	me.HTTPHandleFunc(content.LyricsFolder,
+		func(w http.ResponseWriter, r *http.Request) {
+			log.Tracef("received request for lyrics: %s", r.URL.String())
+
+			path, err := url.QueryUnescape(r.URL.String())
+			if err != nil {
+				log.Errorf("cannot unescape URL: %s", r.URL.String())
+				http.Error(w, fmt.Sprintf("server error: cannot unescape URL: %s", r.URL.String()), http.StatusInternalServerError)
+				return
+			}
+			name := path[len(content.LyricsFolder):]
+			synced := filepath.Ext(name) == ".lrc"
+			if synced {
+				name = name[:len(name)-len(".lrc")]
+			}
+			id, err := strconv.ParseUint(name, 10, 64)
+			if err != nil {
+				log.Error(errors.Wrapf(err, "requested lyrics '%s' not found", path))
+				http.NotFound(w, r)
+				return
+			}
+			lyrics, err := me.cnt.Lyrics(id, synced)
+			if err != nil {
+				log.Error(err)
+				http.NotFound(w, r)
+				return
+			}
+
+			// return lyrics
+			mimeType := content.LyricsMimeType
+			if synced {
+				mimeType = content.LRCMimeType
+			}
+			w.Header().Set("Content-Type", mimeType+"; charset=utf-8")
+			w.Header().Set("Content-Length", strconv.Itoa(len(lyrics)))
+			if _, err := io.WriteString(w, lyrics); err != nil {
+				log.Error(errors.Wrapf(err, "cannot write lyrics of track %d to HTTP response", id))
+			}
+		},
+	)
+
 	// handler for command requests
 	me.HTTPHandleFunc(contentFolder,
 		func(w http.ResponseWriter, r *http.Request) {
@@ -461,8 +507,12 @@ func (me *Server) setHTTPHandler() {
 				me.cnt.AlbumsWithInconsistentTrackNumbers(w)
 			case albumsWithMultipleCovers:
 				me.cnt.AlbumsWithMultipleCovers(w)
+			case albumsWithoutLoudness:
+				me.cnt.AlbumsWithoutLoudness(w)
 			case inconsistentAlbums:
 				me.cnt.InconsistentAlbums(w)
+			case tracksWithCharsetIssues:
+				me.cnt.TracksWithCharsetIssues(w)
 			case tracksWithoutAlbum:
 				me.cnt.TracksWithoutAlbum(w)
 			case tracksWithoutCover: